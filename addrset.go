@@ -0,0 +1,175 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kortschak/mbg/contact"
+)
+
+// addrSet is a set of case folded addresses and domains.
+type addrSet struct {
+	addrs   map[string]bool
+	domains map[string]bool
+}
+
+// readAddrSet returns the address set held in the file at path, with
+// addresses case folded by contact.FoldAddr. The file holds one
+// address per line, or a domain in the form *@domain matching all
+// addresses in the domain. Blank lines and lines starting with # are
+// ignored.
+func readAddrSet(path string, caseSensitiveLocal bool) (addrSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return addrSet{}, err
+	}
+	defer f.Close()
+
+	s := addrSet{addrs: make(map[string]bool), domains: make(map[string]bool)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := contact.FoldAddr(strings.TrimSpace(sc.Text()), caseSensitiveLocal)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "*@") {
+			s.domains[line[len("*@"):]] = true
+		} else {
+			s.addrs[line] = true
+		}
+	}
+	return s, sc.Err()
+}
+
+// contains returns whether the case folded address addr is in s.
+func (s addrSet) contains(addr string) bool {
+	if s.addrs[addr] {
+		return true
+	}
+	i := strings.LastIndex(addr, "@")
+	return i >= 0 && s.domains[addr[i+1:]]
+}
+
+// domainSet is a set of domains. Domains with a leading dot
+// match their subdomains.
+type domainSet struct {
+	domains  map[string]bool
+	suffixes []string
+}
+
+// parseDomainSet returns the domain set in the comma-separated
+// list s, or nil if s is empty.
+func parseDomainSet(s string) *domainSet {
+	if s == "" {
+		return nil
+	}
+	d := domainSet{domains: make(map[string]bool)}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		switch {
+		case f == "":
+		case strings.HasPrefix(f, "."):
+			d.suffixes = append(d.suffixes, f)
+		default:
+			d.domains[f] = true
+		}
+	}
+	return &d
+}
+
+// contains returns whether the domain of the address addr, which
+// must have a lowercased domain, is in d.
+func (d *domainSet) contains(addr string) bool {
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return false
+	}
+	domain := addr[i+1:]
+	if d.domains[domain] {
+		return true
+	}
+	for _, s := range d.suffixes {
+		if strings.HasSuffix(domain, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// readMessageIDs returns the set of message IDs held in the file at
+// path. The file holds one message ID per line, with or without
+// angle brackets. Blank lines and lines starting with # are ignored.
+func readMessageIDs(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ids := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "<") {
+			line = "<" + line + ">"
+		}
+		ids[line] = true
+	}
+	return ids, sc.Err()
+}
+
+// readAliases returns the address aliases held in the file at path,
+// mapping each alias to its canonical address, both case folded by
+// contact.FoldAddr.
+//
+// Each line of the file holds a canonical address followed by a tab
+// and a space-separated list of its aliases. Blank lines and lines
+// starting with # are ignored.
+func readAliases(path string, caseSensitiveLocal bool) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	aliases := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: missing tab after canonical address", path, n)
+		}
+		canon := contact.FoldAddr(strings.TrimSpace(fields[0]), caseSensitiveLocal)
+		if !strings.Contains(canon, "@") || strings.ContainsAny(canon, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid canonical address %q", path, n, canon)
+		}
+		list := strings.Fields(fields[1])
+		if len(list) == 0 {
+			return nil, fmt.Errorf("%s:%d: no aliases for %s", path, n, canon)
+		}
+		for _, a := range list {
+			a = contact.FoldAddr(a, caseSensitiveLocal)
+			if !strings.Contains(a, "@") {
+				return nil, fmt.Errorf("%s:%d: invalid alias %q", path, n, a)
+			}
+			if c, ok := aliases[a]; ok && c != canon {
+				return nil, fmt.Errorf("%s:%d: alias %s already used for %s", path, n, a, c)
+			}
+			aliases[a] = canon
+		}
+	}
+	return aliases, sc.Err()
+}
@@ -0,0 +1,57 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// maxAdjacency is the number of nodes above which a warning is
+// logged when writing an adjacency matrix.
+const maxAdjacency = 2000
+
+// marshalAdjacency writes g to dst as a CSV weighted adjacency
+// matrix. The first row and column hold the node addresses in
+// sorted order and each other cell holds the weight of the edge
+// from the node of its row to the node of its column, or zero if
+// there is no edge.
+func marshalAdjacency(dst io.Writer, g addrGraph) error {
+	nodes := graph.NodesOf(g.Nodes())
+	if len(nodes) > maxAdjacency {
+		slog.Warn("writing large adjacency matrix", "nodes", len(nodes))
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].(person).addr < nodes[j].(person).addr
+	})
+
+	w := csv.NewWriter(dst)
+	row := make([]string, len(nodes)+1)
+	for i, n := range nodes {
+		row[i+1] = n.(person).addr
+	}
+	err := w.Write(row)
+	if err != nil {
+		return err
+	}
+	for _, u := range nodes {
+		row[0] = u.(person).addr
+		for j, v := range nodes {
+			weight, _ := g.Weight(u.ID(), v.ID())
+			row[j+1] = strconv.FormatFloat(weight, 'g', -1, 64)
+		}
+		err = w.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
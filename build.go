@@ -0,0 +1,1240 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kortschak/mbg/contact"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/multi"
+)
+
+// options holds the configuration of graph construction.
+type options struct {
+	// directed specifies that the graph is
+	// directed from senders to recipients.
+	directed bool
+
+	// weight is the edge weight function of
+	// the graph.
+	weight weightFunc
+
+	// originators and recipients are the headers
+	// from which sender and recipient addresses
+	// are extracted.
+	originators, recipients []string
+
+	// include, exclude and dropFrom are the
+	// address filters used by extractAddrs.
+	include, exclude, dropFrom *regexp.Regexp
+
+	// excluded holds addresses that are
+	// excluded in addition to exclude.
+	excluded addrSet
+
+	// dropIDs holds the message IDs of messages
+	// to drop. In thread mode, messages replying
+	// to or referencing them are also dropped.
+	dropIDs map[string]bool
+
+	// hubs holds addresses that are removed from
+	// the recipients of messages. Messages left
+	// with fewer than two addresses by their
+	// removal are dropped.
+	hubs map[string]bool
+
+	// ignoreAutomated specifies that addresses
+	// of automated senders are excluded.
+	ignoreAutomated bool
+
+	// includeDomains and excludeDomains, if
+	// not nil, are the domains of addresses
+	// to include and exclude in addition to
+	// include and exclude.
+	includeDomains, excludeDomains *domainSet
+
+	// maxRecipients, if not zero, is the maximum
+	// number of addresses in a recipient header.
+	// Messages with more are skipped, or if
+	// truncateRecipients is true, only the first
+	// maxRecipients addresses are used.
+	maxRecipients      int
+	truncateRecipients bool
+
+	// headerWeights, if not nil, holds the
+	// weight of lines to addresses found in
+	// each recipient header. Headers without
+	// a weight have weight one.
+	headerWeights map[string]float64
+
+	// edgeHeaders holds the canonical names of
+	// headers whose values are recorded on the
+	// lines of each message.
+	edgeHeaders []string
+
+	// bccMode specifies how addresses found only
+	// in Bcc headers are linked. In clique mode
+	// they are linked like any other recipient,
+	// in to-sender-only mode only to the senders,
+	// since blind recipients do not see the other
+	// recipients, and in ignore mode they are not
+	// used.
+	bccMode string
+
+	// since and until are the bounds of the
+	// time window of messages to include if
+	// they are not zero.
+	since, until time.Time
+
+	// requireDate specifies that messages
+	// without a date are skipped.
+	requireDate bool
+
+	// byDomain specifies that addresses are
+	// replaced by their domain.
+	byDomain bool
+
+	// crossDomain specifies that lines are only
+	// added between addresses in different
+	// domains.
+	crossDomain bool
+
+	// bipartite specifies that lines are added
+	// between each address of a message and the
+	// domains of its addresses instead of between
+	// the addresses.
+	bipartite bool
+
+	// normalizeGmail specifies that Gmail
+	// addresses are canonicalized.
+	normalizeGmail bool
+
+	// caseSensitiveLocal specifies that the
+	// local part of addresses keeps its case.
+	caseSensitiveLocal bool
+
+	// dialect is the mbox dialect of the input.
+	dialect mboxDialect
+
+	// aliases maps address aliases to their
+	// canonical address.
+	aliases map[string]string
+
+	// salt, if not nil, specifies that addresses
+	// are replaced by a hash salted with it.
+	salt []byte
+
+	// thread specifies that lines are added from
+	// the senders of replies to the senders of
+	// the messages they reply to.
+	thread bool
+
+	// bySubject specifies that messages without
+	// reply headers are threaded by subject.
+	bySubject bool
+
+	// slice, if not zero, specifies that lines
+	// are added to a graph in slices for each
+	// time window of this duration, keyed by the
+	// window start, instead of to the graph.
+	// Messages without a date use the zero time
+	// key.
+	slice time.Duration
+
+	// dedup specifies that messages with a
+	// Message-ID that has already been added
+	// are skipped.
+	dedup bool
+
+	// skipOversize specifies that messages
+	// larger than the message buffer are
+	// skipped instead of ending the input.
+	skipOversize bool
+
+	// head and tail, if not zero, are the number
+	// of messages read before the rest of the
+	// input is ignored, and the number of the
+	// last messages read that are used.
+	head, tail int
+
+	// bufSize is the maximum size of a message.
+	bufSize int
+
+	selfLoops bool
+	verbose   bool
+}
+
+// builder adds messages to a contact graph.
+type builder struct {
+	options
+
+	g addrGraph
+
+	// slices holds the graphs of each time window
+	// if options.slice is not zero.
+	slices map[time.Time]addrGraph
+
+	// seen holds the added message IDs if
+	// options.dedup is true.
+	seen map[string]bool
+
+	// scanned is the number of messages read.
+	scanned int
+
+	// recent, if not nil, holds the last messages
+	// read, which are only added to the graph by
+	// addTail once all the input has been read.
+	recent *ring
+
+	// posts and index hold the messages retained
+	// for thread reconstruction.
+	posts []*record
+	index map[string]*record
+
+	// progress, if not nil, receives reports
+	// of the progress of reading messages.
+	progress *progress
+
+	// events, if not nil, receives each line
+	// as an event instead of the graph.
+	events *eventWriter
+
+	stats stats
+}
+
+// newBuilder returns a builder adding messages to a new graph with
+// the given options.
+func newBuilder(opts options) *builder {
+	b := &builder{options: opts, g: newAddrGraph(opts.directed, opts.weight)}
+	if opts.tail > 0 {
+		b.recent = newRing(opts.tail)
+	}
+	return b
+}
+
+// buildGraph returns the graph of the messages in the mbox data in r
+// built with the given options, and the statistics of the messages
+// read. It holds the pipeline of mbg between reading its input and
+// pruning and writing the graph.
+func buildGraph(r io.Reader, opts options) (addrGraph, stats, error) {
+	b := newBuilder(opts)
+	err := b.eachMessage(r, make([]byte, opts.bufSize), b.addMessage)
+	if err != nil {
+		return addrGraph{}, b.stats, err
+	}
+	b.addTail()
+	if b.thread {
+		b.linkThreads()
+	}
+	return b.g, b.stats, nil
+}
+
+// addFiles adds the messages in the mbox files at paths to the
+// graph. Zip archives are expanded to the mbox files they hold.
+// Up to jobs files are parsed concurrently, but messages are
+// added to the graph in input order so that node IDs are assigned
+// deterministically. Files that cannot be opened are skipped. Each
+// concurrent parse uses a message buffer of b.bufSize bytes that is
+// reused for subsequent files. If only the first or last messages
+// are used, files are parsed in turn so that messages are counted
+// in input order.
+func (b *builder) addFiles(paths []string, jobs int) error {
+	if jobs < 1 || b.head > 0 || b.recent != nil {
+		jobs = 1
+	}
+	srcs, archives := b.sources(paths)
+	defer func() {
+		for _, a := range archives {
+			a.Close()
+		}
+	}()
+
+	type result struct {
+		records chan record
+		err     error
+	}
+	results := make([]*result, len(srcs))
+	for i := range results {
+		results[i] = &result{records: make(chan record, 1024)}
+	}
+	go func() {
+		// bufs limits the number of concurrent parses
+		// and holds their lazily allocated buffers.
+		bufs := make(chan []byte, jobs)
+		for i := 0; i < jobs; i++ {
+			bufs <- nil
+		}
+		for i, src := range srcs {
+			buf := <-bufs
+			if buf == nil {
+				buf = make([]byte, b.bufSize)
+			}
+			go func(src source, buf []byte, res *result) {
+				defer func() { bufs <- buf }()
+				defer close(res.records)
+				f, err := src.open()
+				if err != nil {
+					slog.Warn("failed to open input", "path", src.name, "error", err)
+					return
+				}
+				defer f.Close()
+				res.err = b.eachMessage(f, buf, func(h mail.Header, envelope time.Time) {
+					r, ok := b.prepare(h, envelope)
+					if ok {
+						res.records <- r
+					}
+				})
+			}(src, buf, results[i])
+		}
+	}()
+
+	for i, res := range results {
+		for r := range res.records {
+			b.add(r)
+		}
+		if res.err == bufio.ErrTooLong {
+			return fmt.Errorf("%s: message larger than %d byte buffer: increase -buffer or use -skip-oversize", srcs[i].name, b.bufSize)
+		}
+		if res.err != nil {
+			return fmt.Errorf("%s: %v", srcs[i].name, res.err)
+		}
+	}
+	return nil
+}
+
+// eachMessage calls fn with the header of each message in the
+// mbox data in r and the date of its From_ line, or the zero time
+// if it has none, using buf to hold each message. Messages larger
+// than cap(buf) result in a bufio.ErrTooLong error, or if
+// b.skipOversize is true, are logged and skipped. Messages with
+// headers that cannot be parsed are skipped.
+func (b *builder) eachMessage(r io.Reader, buf []byte, fn func(mail.Header, time.Time)) error {
+	ms := newMboxScanner(r, buf, b.dialect)
+	ms.skipLong = b.skipOversize
+	var long int
+	oversize := func() {
+		for ; long < ms.long; long++ {
+			b.stats.count(&b.stats.messages)
+			b.stats.count(&b.stats.oversize)
+			slog.Warn("skipping message larger than buffer", "size", cap(buf))
+		}
+	}
+	defer oversize()
+	for ms.Next() {
+		oversize()
+		if b.head > 0 {
+			if b.scanned >= b.head {
+				break
+			}
+			b.scanned++
+		}
+		envelope, _ := ms.Date()
+		if b.recent != nil {
+			b.recent.push(ms.Bytes(), envelope)
+			continue
+		}
+		b.readMessage(ms.Bytes(), envelope, fn)
+	}
+	return ms.Err()
+}
+
+// readMessage calls fn with the header of the message in data and
+// its envelope date. Messages with headers that cannot be parsed
+// are skipped.
+func (b *builder) readMessage(data []byte, envelope time.Time, fn func(mail.Header, time.Time)) {
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		b.stats.count(&b.stats.messages)
+		b.stats.count(&b.stats.malformed)
+		slog.Debug("skipping malformed message", "error", err)
+		return
+	}
+	fn(m.Header, envelope)
+}
+
+// addTail adds the messages retained in b.recent to the graph in
+// the order they were read.
+func (b *builder) addTail() {
+	if b.recent == nil {
+		return
+	}
+	b.recent.each(func(data []byte, envelope time.Time) {
+		b.readMessage(data, envelope, b.addMessage)
+	})
+}
+
+// ring is a ring buffer holding the raw bytes and envelope dates
+// of the last messages pushed to it.
+type ring struct {
+	msgs []rawMessage
+	next int
+	full bool
+}
+
+// rawMessage is the raw bytes of a message and its envelope date.
+type rawMessage struct {
+	data     []byte
+	envelope time.Time
+}
+
+// newRing returns a ring holding up to n messages.
+func newRing(n int) *ring {
+	return &ring{msgs: make([]rawMessage, n)}
+}
+
+// push adds a copy of the message in data with the given envelope
+// date to r, replacing the oldest message if r is full.
+func (r *ring) push(data []byte, envelope time.Time) {
+	m := &r.msgs[r.next]
+	m.data = append(m.data[:0], data...)
+	m.envelope = envelope
+	r.next++
+	if r.next == len(r.msgs) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// each calls fn with each message held by r from oldest to newest.
+func (r *ring) each(fn func(data []byte, envelope time.Time)) {
+	if r.full {
+		for _, m := range r.msgs[r.next:] {
+			fn(m.data, m.envelope)
+		}
+	}
+	for _, m := range r.msgs[:r.next] {
+		fn(m.data, m.envelope)
+	}
+}
+
+// record is the contribution of a single message to the graph.
+type record struct {
+	// found holds the addresses extracted from the
+	// message, with the first senders elements from
+	// originator headers.
+	found   []address
+	senders int
+
+	date time.Time
+	mid  string
+
+	// dateSource is the source of date: the
+	// Date or Received header, or the envelope.
+	// It is empty if the message has no date.
+	dateSource string
+
+	// subject is the decoded and normalized
+	// subject of the message.
+	subject string
+
+	// headers holds the values of the headers
+	// named by edgeHeaders that the message has.
+	headers []headerValue
+
+	// parent is the message ID of the message
+	// replied to. It is only used for threading.
+	parent string
+
+	// weights holds the greatest header weight
+	// of each recipient address if header
+	// weights are used.
+	weights map[string]float64
+}
+
+// headerValue is the value of a header of a message.
+type headerValue struct {
+	name, value string
+}
+
+// weight returns the weight of a line between the addresses p
+// and q of the message: the greater of the header weights of the
+// addresses that were recipients, or one if neither was.
+func (r *record) weight(p, q string) float64 {
+	wp, okp := r.weights[p]
+	wq, okq := r.weights[q]
+	switch {
+	case okp && okq:
+		return math.Max(wp, wq)
+	case okp:
+		return wp
+	case okq:
+		return wq
+	default:
+		return 1
+	}
+}
+
+// addMessage adds lines between the addresses in the message
+// with the header h and envelope date to the graph.
+func (b *builder) addMessage(h mail.Header, envelope time.Time) {
+	r, ok := b.prepare(h, envelope)
+	if ok {
+		b.add(r)
+	}
+}
+
+// prepare returns the record for the message with the header h
+// and envelope date, which is used if the header has no date, and
+// whether the message should be added to the graph. It does
+// not alter the graph and is safe for concurrent use.
+func (b *builder) prepare(h mail.Header, envelope time.Time) (r record, ok bool) {
+	b.stats.count(&b.stats.messages)
+	if !b.hasAddrHeader(h) {
+		b.stats.count(&b.stats.malformed)
+		slog.Debug("skipping message without address headers", "message-id", strings.TrimSpace(h.Get("message-id")))
+		return r, false
+	}
+	r.found, ok = b.senders(h)
+	if !ok || b.isDroppedID(h) {
+		b.stats.count(&b.stats.dropped)
+		return r, false
+	}
+	r.senders = len(r.found)
+	var err error
+	r.date, r.dateSource, err = contact.MessageDate(h, envelope)
+	if err != nil {
+		slog.Debug("failed to extract date", "message-id", strings.TrimSpace(h.Get("message-id")), "error", err)
+	}
+	if b.requireDate && r.date.IsZero() {
+		b.stats.count(&b.stats.undated)
+		return r, false
+	}
+	r.subject = normalizeSubject(contact.DecodeWords(h.Get("subject")))
+	for _, name := range b.edgeHeaders {
+		v := strings.TrimSpace(contact.DecodeWords(h.Get(name)))
+		if v != "" {
+			r.headers = append(r.headers, headerValue{name: name, value: v})
+		}
+	}
+	if b.thread {
+		r.mid = strings.TrimSpace(h.Get("message-id"))
+		r.parent = parentID(h)
+		return r, true
+	}
+
+	for _, tag := range b.recipients {
+		if tag == "bcc" && b.bccMode == "ignore" {
+			continue
+		}
+		n := len(r.found)
+		r.found, err = b.extractAddrs(r.found, h, tag, nil)
+		if err == tooManyAddrs {
+			b.stats.count(&b.stats.tooMany)
+			return r, false
+		}
+		if err != nil {
+			slog.Debug("failed to extract address list", "header", tag, "message-id", strings.TrimSpace(h.Get("message-id")), "error", err)
+		}
+		if tag == "bcc" && b.bccMode == "to-sender-only" {
+			for i := range r.found[n:] {
+				r.found[n+i].bcc = true
+			}
+		}
+		if b.headerWeights != nil {
+			w, ok := b.headerWeights[tag]
+			if !ok {
+				w = 1
+			}
+			if r.weights == nil {
+				r.weights = make(map[string]float64)
+			}
+			for _, a := range r.found[n:] {
+				if v, ok := r.weights[a.addr]; !ok || w > v {
+					r.weights[a.addr] = w
+				}
+			}
+		}
+	}
+	if !b.inWindow(r.date) {
+		b.stats.count(&b.stats.outside)
+		return r, false
+	}
+	if b.hubs != nil {
+		var ok bool
+		r.found, ok = b.withoutHubs(r.found, r.senders)
+		if !ok {
+			b.stats.count(&b.stats.dropped)
+			return r, false
+		}
+	}
+	if len(r.found) < 2 {
+		b.stats.count(&b.stats.tooFew)
+		return r, false
+	}
+	r.mid = strings.TrimSpace(h.Get("message-id"))
+	return r, true
+}
+
+// add adds lines between the addresses in the record r to the
+// graph, or retains r for thread reconstruction. If b.dedup is
+// true, records with a previously added message ID are skipped.
+func (b *builder) add(r record) {
+	if b.progress != nil {
+		b.reportProgress(false)
+	}
+	if b.dedup && r.mid != "" {
+		if b.seen[r.mid] {
+			b.stats.count(&b.stats.duplicate)
+			slog.Debug("skipping duplicate message", "message-id", r.mid)
+			return
+		}
+		if b.seen == nil {
+			b.seen = make(map[string]bool)
+		}
+		b.seen[r.mid] = true
+	}
+	if b.thread {
+		b.retain(&r)
+		b.stats.count(&b.stats.added)
+		return
+	}
+
+	if b.events != nil {
+		defer b.events.flush()
+	}
+	g := b.graph(r.date)
+	addrs := make([]string, len(r.found))
+	var aliases map[string][]string
+	for i, a := range r.found {
+		addrs[i] = a.addr
+		if a.raw != "" && !contains(aliases[a.addr], a.raw) {
+			if aliases == nil {
+				aliases = make(map[string][]string)
+			}
+			aliases[a.addr] = append(aliases[a.addr], a.raw)
+		}
+	}
+
+	if b.bipartite {
+		b.addBipartite(g, &r, addrs, aliases)
+		return
+	}
+
+	if g.isDirected() {
+		var n int
+		from, to := dedup(addrs[:r.senders]), dedup(addrs[r.senders:])
+		k := len(dedup(append(append([]string(nil), from...), to...)))
+		fromNodes, toNodes := make([]graph.Node, len(from)), make([]graph.Node, len(to))
+		for i, p := range from {
+			for j, q := range to {
+				if p == q && !b.selfLoops || b.crossDomain && sameDomain(p, q) {
+					continue
+				}
+				b.addLine(g, b.resolve(g, fromNodes, from, i), b.resolve(g, toNodes, to, j), &r, raw(aliases, p, q), k)
+				n++
+			}
+		}
+		if n == 0 {
+			b.notEnough(r.date)
+			return
+		}
+		b.stats.count(&b.stats.added)
+		b.named(g, r.found)
+		return
+	}
+
+	k := len(dedup(append([]string(nil), addrs...)))
+	var blind []string
+	if b.bccMode == "to-sender-only" {
+		addrs, blind = splitBlind(r.found, addrs)
+	}
+	var n int
+	if b.selfLoops && !b.crossDomain {
+		for _, p := range repeated(addrs) {
+			u := b.node(g, p)
+			b.addLine(g, u, u, &r, raw(aliases, p, ""), k)
+			n++
+		}
+	}
+	addrs = dedup(addrs)
+	nodes := make([]graph.Node, len(addrs))
+	for i, p := range addrs {
+		for j := i + 1; j < len(addrs); j++ {
+			q := addrs[j]
+			if b.crossDomain && sameDomain(p, q) {
+				continue
+			}
+			b.addLine(g, b.resolve(g, nodes, addrs, i), b.resolve(g, nodes, addrs, j), &r, raw(aliases, p, q), k)
+			n++
+		}
+	}
+	// Blind recipients are only linked to
+	// the senders.
+	var senders []string
+	if len(blind) != 0 {
+		for _, a := range r.found[:r.senders] {
+			senders = append(senders, a.addr)
+		}
+		senders = dedup(senders)
+	}
+	for _, q := range blind {
+		v := b.node(g, q)
+		for _, p := range senders {
+			if b.crossDomain && sameDomain(p, q) {
+				continue
+			}
+			b.addLine(g, b.node(g, p), v, &r, raw(aliases, p, q), k)
+			n++
+		}
+	}
+	if n == 0 {
+		b.notEnough(r.date)
+		return
+	}
+	b.stats.count(&b.stats.added)
+	b.named(g, r.found)
+}
+
+// reportProgress reports the number of messages seen and the
+// size of the graph to b.progress. If done is true, the report
+// is final.
+func (b *builder) reportProgress(done bool) {
+	nodes := b.g.Nodes().Len()
+	for _, g := range b.slices {
+		nodes += g.Nodes().Len()
+	}
+	messages := atomic.LoadInt64(&b.stats.messages)
+	lines := int(atomic.LoadInt64(&b.stats.lines))
+	if done {
+		b.progress.done(messages, nodes, lines)
+	} else {
+		b.progress.report(messages, nodes, lines)
+	}
+}
+
+// newest returns the date of the newest message in the graphs
+// built by b, or the zero time if no message has a date.
+func (b *builder) newest() time.Time {
+	graphs := []addrGraph{b.g}
+	for _, g := range b.slices {
+		graphs = append(graphs, g)
+	}
+	var t time.Time
+	for _, g := range graphs {
+		edges := g.Edges()
+		for edges.Next() {
+			lines := edges.Edge().(multi.Edge).Lines
+			for lines.Next() {
+				d := lines.Line().(message).date
+				if d.After(t) {
+					t = d
+				}
+			}
+			lines.Reset()
+		}
+	}
+	return t
+}
+
+// graph returns the graph that lines for a message with the
+// given date are added to.
+func (b *builder) graph(date time.Time) addrGraph {
+	if b.slice <= 0 {
+		return b.g
+	}
+	var start time.Time
+	if !date.IsZero() {
+		start = date.Truncate(b.slice).UTC()
+	}
+	g, ok := b.slices[start]
+	if !ok {
+		g = newAddrGraph(b.g.isDirected(), b.g.weight)
+		if b.slices == nil {
+			b.slices = make(map[time.Time]addrGraph)
+		}
+		b.slices[start] = g
+	}
+	return g
+}
+
+// senders returns the addresses in the originator headers of h.
+// If any address matches b.dropFrom, senders returns false.
+func (b *builder) senders(h mail.Header) (addrs []address, ok bool) {
+	for _, tag := range b.originators {
+		var err error
+		addrs, err = b.extractAddrs(addrs, h, tag, b.dropFrom)
+		if err != nil {
+			if err == dropMessage {
+				return nil, false
+			}
+			slog.Debug("failed to extract address list", "header", tag, "message-id", strings.TrimSpace(h.Get("message-id")), "error", err)
+		}
+	}
+	return addrs, true
+}
+
+// hasAddrHeader returns whether h has any of the selected address
+// headers. Messages without them are likely to be fragments of a
+// message split at an unescaped From_ line in its body.
+func (b *builder) hasAddrHeader(h mail.Header) bool {
+	for _, tags := range [][]string{b.originators, b.recipients} {
+		for _, tag := range tags {
+			if _, ok := h[textproto.CanonicalMIMEHeaderKey(tag)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headerRoles holds the address headers that can be selected with
+// the -headers flag, mapped to whether they identify an originator
+// of the message.
+var headerRoles = map[string]bool{
+	"from":     true,
+	"sender":   true,
+	"reply-to": true,
+	"to":       false,
+	"cc":       false,
+	"bcc":      false,
+
+	// Delivered-To and X-Original-To hold the
+	// recipient of mail delivered through an
+	// alias or forwarding address.
+	"delivered-to":  false,
+	"x-original-to": false,
+}
+
+// parseHeaderWeights returns the recipient header weights in the
+// comma-separated list of header=weight pairs in s.
+func parseHeaderWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		i := strings.Index(f, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("missing = in %q", f)
+		}
+		tag := strings.ToLower(strings.TrimSpace(f[:i]))
+		if originator, ok := headerRoles[tag]; !ok || originator {
+			return nil, fmt.Errorf("not a recipient header: %q", tag)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(f[i+1:]), 64)
+		if err != nil || w < 0 || math.IsInf(w, 0) {
+			return nil, fmt.Errorf("invalid weight for %s: %q", tag, f[i+1:])
+		}
+		weights[tag] = w
+	}
+	return weights, nil
+}
+
+// parseHeaders returns the originator and recipient headers named
+// in the comma-separated list s, and any names in s that are not
+// known address headers. Empty names are ignored.
+func parseHeaders(s string) (originators, recipients, unknown []string) {
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		originator, ok := headerRoles[tag]
+		switch {
+		case !ok:
+			unknown = append(unknown, tag)
+		case originator:
+			originators = append(originators, tag)
+		default:
+			recipients = append(recipients, tag)
+		}
+	}
+	return originators, recipients, unknown
+}
+
+// named records the display names of addrs in g.
+func (b *builder) named(g addrGraph, addrs []address) {
+	if b.events != nil {
+		return
+	}
+	for _, a := range addrs {
+		g.named(a.addr, a.name)
+	}
+}
+
+// setHubs sets the hub addresses removed from the recipients of
+// messages to the addresses in the comma-separated list s, in the
+// canonical form given by extractAddrs.
+func (b *builder) setHubs(s string) {
+	for _, addr := range strings.Split(s, ",") {
+		addr = contact.FoldAddr(strings.TrimSpace(addr), b.caseSensitiveLocal)
+		if addr == "" {
+			continue
+		}
+		if b.normalizeGmail {
+			addr = contact.NormalizeGmail(addr)
+		}
+		if canon, ok := b.aliases[addr]; ok {
+			addr = canon
+		}
+		if b.hubs == nil {
+			b.hubs = make(map[string]bool)
+		}
+		b.hubs[b.anonymize(addr)] = true
+	}
+}
+
+// withoutHubs returns found with the hub addresses removed from the
+// recipients that follow the first senders addresses. If any were
+// removed and fewer than two distinct addresses remain, withoutHubs
+// returns false.
+func (b *builder) withoutHubs(found []address, senders int) ([]address, bool) {
+	kept := found[:senders]
+	for _, a := range found[senders:] {
+		if !b.hubs[a.addr] {
+			kept = append(kept, a)
+		}
+	}
+	if len(kept) == len(found) {
+		return found, true
+	}
+	distinct := make(map[string]bool)
+	for _, a := range kept {
+		distinct[a.addr] = true
+	}
+	return kept, len(distinct) >= 2
+}
+
+// node returns the node of g for addr. If events are being written,
+// no node is added to g and the returned person only holds addr.
+func (b *builder) node(g addrGraph, addr string) graph.Node {
+	if b.events != nil {
+		return person{addr: addr}
+	}
+	return g.person(addr)
+}
+
+// resolve returns the node of g for addrs[i], which is held in
+// nodes[i] once it has been looked up, so that the nodes of the
+// addresses of a message are looked up once rather than for each
+// pair of addresses.
+func (b *builder) resolve(g addrGraph, nodes []graph.Node, addrs []string, i int) graph.Node {
+	if nodes[i] == nil {
+		nodes[i] = b.node(g, addrs[i])
+	}
+	return nodes[i]
+}
+
+// addLine adds a line between the nodes p and q for the message r
+// to g, with the raw forms of the addresses and the number of
+// distinct addresses, k, on the message. If events are being
+// written, the line is written as an event instead.
+func (b *builder) addLine(g addrGraph, p, q graph.Node, r *record, raw []string, k int) {
+	b.stats.count(&b.stats.lines)
+	u, v := p.(person).addr, q.(person).addr
+	if b.events != nil {
+		e := event{From: u, To: v, MID: r.mid, Subject: r.subject, Participants: k}
+		if !r.date.IsZero() {
+			e.Date = r.date.Format(time.RFC3339)
+		}
+		if b.headerWeights != nil {
+			w := r.weight(u, v)
+			e.Weight = &w
+		}
+		b.events.write(e)
+		return
+	}
+	l := g.message(p, q, r.date, r.mid)
+	l.subject = r.subject
+	l.headers = r.headers
+	l.raw = raw
+	l.participants = k
+	l.factor = r.weight(u, v)
+	if b.verbose {
+		l.dateSource = r.dateSource
+	}
+	g.SetLine(l)
+}
+
+// isDroppedID returns whether the message with the header h has a
+// message ID in b.dropIDs, or in thread mode, whether it replies to
+// or references a message with an ID in b.dropIDs.
+func (b *builder) isDroppedID(h mail.Header) bool {
+	if len(b.dropIDs) == 0 {
+		return false
+	}
+	tags := []string{"message-id"}
+	if b.thread {
+		tags = append(tags, "in-reply-to", "references")
+	}
+	for _, tag := range tags {
+		for _, id := range messageIDs(h.Get(tag)) {
+			if b.dropIDs[id] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addBipartite adds lines from each distinct address in addrs, which
+// are the addresses of the record r, to each distinct domain of the
+// addresses, and marks the nodes with their kind.
+func (b *builder) addBipartite(g addrGraph, r *record, addrs []string, aliases map[string][]string) {
+	addrs = dedup(addrs)
+	if len(addrs) < 2 {
+		b.notEnough(r.date)
+		return
+	}
+	var domains []string
+	for _, a := range addrs {
+		if d, ok := domain(a); ok {
+			domains = append(domains, d)
+		}
+	}
+	domains = dedup(domains)
+	for _, p := range addrs {
+		for _, d := range domains {
+			b.addLine(g, b.node(g, p), b.node(g, d), r, raw(aliases, p, ""), len(addrs))
+		}
+	}
+	for _, p := range addrs {
+		g.setKind(p, "person")
+	}
+	for _, d := range domains {
+		g.setKind(d, "domain")
+	}
+	b.stats.count(&b.stats.added)
+	b.named(g, r.found)
+}
+
+// domain returns the domain of addr and whether it has one.
+func domain(addr string) (string, bool) {
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return "", false
+	}
+	return addr[i+1:], true
+}
+
+// sameDomain returns whether the addresses p and q have the same
+// domain. An address without an @ is its own domain.
+func sameDomain(p, q string) bool {
+	if d, ok := domain(p); ok {
+		p = d
+	}
+	if d, ok := domain(q); ok {
+		q = d
+	}
+	return p == q
+}
+
+// raw returns the raw forms of the addresses x and y
+// recorded in aliases.
+func raw(aliases map[string][]string, x, y string) []string {
+	n := len(aliases[x]) + len(aliases[y])
+	if n == 0 {
+		return nil
+	}
+	r := make([]string, 0, n)
+	r = append(r, aliases[x]...)
+	return append(r, aliases[y]...)
+}
+
+// contains returns whether s is in list.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// address is an address extracted from a message header.
+type address struct {
+	// name is the display name for the address.
+	name string
+
+	// addr is the canonical form of the address.
+	addr string
+
+	// raw is the address as it appeared in the
+	// header if it differs from addr other than
+	// by case.
+	raw string
+
+	// bcc is whether the address was found in a
+	// Bcc header when blind recipients are only
+	// linked to the senders.
+	bcc bool
+}
+
+// splitBlind returns the addresses in addrs, which holds the
+// addresses of found, that are not blind recipients, and the
+// distinct blind recipients, which are the addresses only found
+// in Bcc headers.
+func splitBlind(found []address, addrs []string) (seen, blind []string) {
+	visible := make(map[string]bool)
+	for _, a := range found {
+		if !a.bcc {
+			visible[a.addr] = true
+		}
+	}
+	seen = make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if visible[a] {
+			seen = append(seen, a)
+		} else {
+			blind = append(blind, a)
+		}
+	}
+	return seen, dedup(blind)
+}
+
+// extractAddrs appends the addresses in all tag headers of h to dst,
+// with each address case folded by contact.FoldAddr and its decoded
+// display name retained. If the header cannot be parsed as an address
+// list, the addresses in it that can be parsed individually are used.
+// If b.normalizeGmail is true, Gmail addresses are canonicalized, and
+// then addresses in b.aliases are replaced with their canonical
+// address. If b.include is not nil, only addresses matching it are
+// appended, and if b.includeDomains is not nil, only addresses in its
+// domains are appended. Addresses matching b.exclude, in b.excluded,
+// in the domains of b.excludeDomains or, if b.ignoreAutomated is
+// true, of automated senders are never appended, and originator
+// addresses excluded other than as automated senders are logged at
+// debug level. If any address matches drop, extractAddrs returns
+// dropMessage. If a recipient header has more than b.maxRecipients
+// addresses, only the first are used if b.truncateRecipients is true,
+// and otherwise extractAddrs returns tooManyAddrs. If b.byDomain is
+// true, the domain of each address is appended without a name. If
+// b.salt is not nil, addresses are anonymized after filtering and
+// names are not retained.
+func (b *builder) extractAddrs(dst []address, h mail.Header, tag string, drop *regexp.Regexp) ([]address, error) {
+	var addrs []*mail.Address
+	for _, v := range h[textproto.CanonicalMIMEHeaderKey(tag)] {
+		addrs = append(addrs, contact.ParseAddressList(v, func(addr string, err error) {
+			slog.Debug("failed to parse address", "header", tag, "address", addr, "error", err)
+		})...)
+	}
+	if b.maxRecipients > 0 && len(addrs) > b.maxRecipients && !headerRoles[tag] {
+		if !b.truncateRecipients {
+			slog.Info("skipping message with too many recipients", "header", tag, "recipients", len(addrs), "message-id", strings.TrimSpace(h.Get("message-id")))
+			return dst, tooManyAddrs
+		}
+		slog.Info("truncating recipients", "header", tag, "recipients", len(addrs), "message-id", strings.TrimSpace(h.Get("message-id")))
+		addrs = addrs[:b.maxRecipients]
+	}
+	for _, a := range addrs {
+		addr := contact.FoldAddr(a.Address, b.caseSensitiveLocal)
+		var raw string
+		if b.normalizeGmail {
+			canon := contact.NormalizeGmail(addr)
+			if canon != addr {
+				raw = a.Address
+				addr = canon
+			}
+		}
+		if canon, ok := b.aliases[addr]; ok {
+			raw = a.Address
+			addr = canon
+		}
+		if drop != nil && drop.MatchString(addr) {
+			b.stats.droppedAddrs.add(addr)
+			return nil, dropMessage
+		}
+		if b.include != nil && !b.include.MatchString(addr) {
+			continue
+		}
+		if b.includeDomains != nil && !b.includeDomains.contains(addr) {
+			continue
+		}
+		if b.exclude != nil && b.exclude.MatchString(addr) || b.excluded.contains(addr) ||
+			b.excludeDomains != nil && b.excludeDomains.contains(addr) {
+			// Excluding a sender removes all the lines
+			// from them, which is easy to do by accident
+			// with a broad pattern.
+			if headerRoles[tag] {
+				slog.Debug("excluding sender address", "header", tag, "address", addr, "message-id", strings.TrimSpace(h.Get("message-id")))
+			}
+			b.stats.excludedAddrs.add(addr)
+			continue
+		}
+		if b.ignoreAutomated && b.isAutomated(addr) {
+			b.stats.excludedAddrs.add(addr)
+			continue
+		}
+		if b.byDomain {
+			i := strings.LastIndex(addr, "@")
+			if i < 0 {
+				slog.Debug("no domain in address", "header", tag, "address", addr)
+				continue
+			}
+			dst = append(dst, address{addr: b.anonymize(addr[i+1:])})
+			continue
+		}
+		if b.salt != nil {
+			dst = append(dst, address{addr: b.anonymize(addr)})
+			continue
+		}
+		dst = append(dst, address{name: contact.DecodeWords(a.Name), addr: addr, raw: raw})
+	}
+	return dst, nil
+}
+
+// isAutomated returns whether the local part of addr matches one of
+// the automated sender patterns. The pattern matched is logged if
+// b.verbose is true.
+func (b *builder) isAutomated(addr string) bool {
+	pattern, ok := contact.Automated(addr)
+	if ok {
+		slog.Debug("ignoring automated address", "address", addr, "pattern", pattern)
+	}
+	return ok
+}
+
+// anonymize returns the first 10 hex digits of the SHA-256 hash
+// of s salted with b.salt, or s if b.salt is nil.
+func (b *builder) anonymize(s string) string {
+	if b.salt == nil {
+		return s
+	}
+	h := sha256.New()
+	h.Write(b.salt)
+	io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+// inWindow returns whether date is within the time window of
+// messages to include. Messages without a date are excluded if
+// either bound of the window is set.
+func (b *builder) inWindow(date time.Time) bool {
+	if b.since.IsZero() && b.until.IsZero() {
+		return true
+	}
+	if date.IsZero() {
+		slog.Debug("excluding message without date from time window")
+		return false
+	}
+	return (b.since.IsZero() || !date.Before(b.since)) && (b.until.IsZero() || !date.After(b.until))
+}
+
+// notEnough counts and logs a message with the given date that
+// did not have enough addresses to add to the graph.
+func (b *builder) notEnough(date time.Time) {
+	b.stats.count(&b.stats.tooFew)
+	if date.IsZero() {
+		slog.Debug("not enough addresses")
+	} else {
+		slog.Debug("not enough addresses", "date", date)
+	}
+}
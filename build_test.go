@@ -0,0 +1,291 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// BenchmarkAddMessage measures adding a single message with
+// many recipients, where the lines of the clique dominate.
+func BenchmarkAddMessage(b *testing.B) {
+	for _, k := range []int{10, 100, 500} {
+		to := make([]string, k)
+		for i := range to {
+			to[i] = fmt.Sprintf("user%d@example.com", i)
+		}
+		h := mail.Header{
+			"From":       {"sender@example.com"},
+			"To":         {strings.Join(to, ", ")},
+			"Date":       {"Mon, 2 Jan 2006 15:04:05 -0700"},
+			"Message-Id": {"<bench@example.com>"},
+		}
+		b.Run(fmt.Sprintf("k=%d", k), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bl := newBuilder(options{
+					weight:      messageCount,
+					originators: []string{"from"},
+					recipients:  []string{"to"},
+				})
+				bl.addMessage(h, time.Time{})
+			}
+		})
+	}
+}
+
+const testMbox = `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+To: bob@example.com, carol@example.com
+Date: Mon, 2 Jan 2006 15:04:05 -0700
+Message-Id: <1@example.com>
+
+Hello.
+
+From bob@example.com Mon Jan  2 16:04:05 2006
+From: bob@example.com
+To: alice@example.com
+Cc: spam@example.net
+Date: Mon, 2 Jan 2006 16:04:05 -0700
+Message-Id: <2@example.com>
+
+Reply.
+
+From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+To: bob@example.com, carol@example.com
+Date: Mon, 2 Jan 2006 15:04:05 -0700
+Message-Id: <1@example.com>
+
+Hello again.
+
+From dave@example.org Mon Jan  2 17:04:05 2006
+From: dave@example.org
+To: alice@example.com
+Date: Mon, 2 Jan 2006 17:04:05 -0700
+Message-Id: <3@example.org>
+
+Unwanted.
+`
+
+var buildGraphTests = []struct {
+	name string
+	opts options
+
+	wantNodes     int
+	wantEdges     int
+	wantLines     int
+	wantDropped   int64
+	wantDuplicate int64
+}{
+	{
+		name:      "all",
+		wantNodes: 5, wantEdges: 6, wantLines: 10,
+	},
+	{
+		name:      "exclude",
+		opts:      options{exclude: regexp.MustCompile(`@example\.net$`)},
+		wantNodes: 4, wantEdges: 4, wantLines: 8,
+	},
+	{
+		name:      "drop_from",
+		opts:      options{dropFrom: regexp.MustCompile(`^dave@`)},
+		wantNodes: 4, wantEdges: 5, wantLines: 9, wantDropped: 1,
+	},
+	{
+		name:      "drop_ids",
+		opts:      options{dropIDs: map[string]bool{"<2@example.com>": true}},
+		wantNodes: 4, wantEdges: 4, wantLines: 7, wantDropped: 1,
+	},
+	{
+		name:      "dedup",
+		opts:      options{dedup: true},
+		wantNodes: 5, wantEdges: 6, wantLines: 7, wantDuplicate: 1,
+	},
+}
+
+func TestBuildGraph(t *testing.T) {
+	originators, recipients, _ := parseHeaders("from,to,cc,bcc")
+	for _, test := range buildGraphTests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := test.opts
+			opts.weight = messageCount
+			opts.originators = originators
+			opts.recipients = recipients
+			opts.bufSize = 1 << 16
+			g, st, err := buildGraph(strings.NewReader(testMbox), opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n := g.Nodes().Len(); n != test.wantNodes {
+				t.Errorf("unexpected number of nodes: got:%d want:%d", n, test.wantNodes)
+			}
+			var edges, lines int
+			for _, e := range g.sortedEdges() {
+				edges++
+				lines += e.Lines.Len()
+			}
+			if edges != test.wantEdges {
+				t.Errorf("unexpected number of edges: got:%d want:%d", edges, test.wantEdges)
+			}
+			if lines != test.wantLines {
+				t.Errorf("unexpected number of lines: got:%d want:%d", lines, test.wantLines)
+			}
+			if st.dropped != test.wantDropped {
+				t.Errorf("unexpected number of dropped messages: got:%d want:%d", st.dropped, test.wantDropped)
+			}
+			if st.duplicate != test.wantDuplicate {
+				t.Errorf("unexpected number of duplicate messages: got:%d want:%d", st.duplicate, test.wantDuplicate)
+			}
+		})
+	}
+}
+
+// unescapedFromMbox holds a message with an unescaped body line that
+// is indistinguishable from a From_ line, so the rest of the body is
+// split into a fragment without address headers.
+const unescapedFromMbox = `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+To: bob@example.com
+Date: Mon, 2 Jan 2006 15:04:05 -0700
+Message-Id: <1@example.com>
+
+Forwarded below.
+
+From mallory@example.com Mon Jan  2 14:04:05 2006
+Subject line: not a header
+mallory@example.com wrote this.
+`
+
+func TestBuildGraphUnescapedFrom(t *testing.T) {
+	originators, recipients, _ := parseHeaders("from,to,cc,bcc")
+	g, st, err := buildGraph(strings.NewReader(unescapedFromMbox), options{
+		weight:      messageCount,
+		originators: originators,
+		recipients:  recipients,
+		bufSize:     1 << 16,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.messages != 2 {
+		t.Errorf("unexpected number of messages: got:%d want:2", st.messages)
+	}
+	if st.malformed != 1 {
+		t.Errorf("unexpected number of malformed messages: got:%d want:1", st.malformed)
+	}
+	var got []string
+	for _, p := range g.sortedPeople() {
+		got = append(got, p.addr)
+	}
+	want := []string{"alice@example.com", "bob@example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected nodes: got:%q want:%q", got, want)
+	}
+}
+
+func TestBuildGraphCRLF(t *testing.T) {
+	originators, recipients, _ := parseHeaders("from,to,cc,bcc")
+	opts := options{
+		weight:      messageCount,
+		originators: originators,
+		recipients:  recipients,
+		bufSize:     1 << 16,
+	}
+	want, _, err := buildGraph(strings.NewReader(testMbox), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	crlf := strings.ReplaceAll(testMbox, "\n", "\r\n")
+	got, _, err := buildGraph(strings.NewReader(crlf), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var gotAddrs, wantAddrs []string
+	for _, p := range got.sortedPeople() {
+		if strings.ContainsAny(p.addr, "\r\n") {
+			t.Errorf("unexpected line ending in address: %q", p.addr)
+		}
+		gotAddrs = append(gotAddrs, p.addr)
+	}
+	for _, p := range want.sortedPeople() {
+		wantAddrs = append(wantAddrs, p.addr)
+	}
+	if !reflect.DeepEqual(gotAddrs, wantAddrs) {
+		t.Errorf("unexpected nodes: got:%q want:%q", gotAddrs, wantAddrs)
+	}
+	if n, m := len(got.sortedEdges()), len(want.sortedEdges()); n != m {
+		t.Errorf("unexpected number of edges: got:%d want:%d", n, m)
+	}
+}
+
+var addrListTests = []struct {
+	list string
+	want []string
+}{
+	{
+		list: `"Doe, Jane" <jane@example.com>, bob@example.org`,
+		want: []string{"jane@example.com", "bob@example.org"},
+	},
+	{
+		list: `jane@example.com (Doe, Jane), bob@example.org`,
+		want: []string{"jane@example.com", "bob@example.org"},
+	},
+	{
+		list: `"Doe, Jane" <jane@example.com>, bob@example.org, <broken`,
+		want: []string{"jane@example.com", "bob@example.org"},
+	},
+}
+
+func TestExtractAddrs(t *testing.T) {
+	b := newBuilder(options{weight: messageCount})
+	for _, test := range addrListTests {
+		addrs, err := b.extractAddrs(nil, mail.Header{"To": {test.list}}, "to", nil)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.list, err)
+			continue
+		}
+		var got []string
+		for _, a := range addrs {
+			got = append(got, a.addr)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected addresses for %q: got:%q want:%q", test.list, got, test.want)
+		}
+	}
+}
+
+var encodedNameTests = []struct {
+	header string
+	want   string
+}{
+	{header: `=?UTF-8?B?SsO8cmdlbiBHcm/Dnw==?= <j@example.com>`, want: "Jürgen Groß"},
+	{header: `=?UTF-8?Q?J=C3=BCrgen_Gro=C3=9F?= <j@example.com>`, want: "Jürgen Groß"},
+	{header: `=?ISO-8859-1?Q?J=FCrgen_Gro=DF?= <j@example.com>`, want: "Jürgen Groß"},
+	{header: `"=?UTF-8?B?SsO8cmdlbiBHcm/Dnw==?=" <j@example.com>`, want: "Jürgen Groß"},
+}
+
+func TestEncodedNames(t *testing.T) {
+	b := newBuilder(options{weight: messageCount})
+	for _, test := range encodedNameTests {
+		addrs, err := b.extractAddrs(nil, mail.Header{"From": {test.header}}, "from", nil)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.header, err)
+			continue
+		}
+		if len(addrs) != 1 {
+			t.Errorf("unexpected number of addresses for %q: got:%d want:1", test.header, len(addrs))
+			continue
+		}
+		if addrs[0].name != test.want {
+			t.Errorf("unexpected name for %q: got:%q want:%q", test.header, addrs[0].name, test.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/encoding"
+	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/iterator"
+)
+
+// clusterByDomain groups the nodes of c into DOT clusters by the
+// domain of their address. Nodes without a domain are not
+// clustered.
+func (c *encodableGraph) clusterByDomain() {
+	var domains []string
+	members := make(map[string][]graph.Node)
+	for _, p := range c.sortedPeople() {
+		d, ok := domain(p.addr)
+		if !ok {
+			continue
+		}
+		if _, ok := members[d]; !ok {
+			domains = append(domains, d)
+		}
+		members[d] = append(members[d], p)
+	}
+	directed := c.isDirected()
+	c.clustered = make(map[int64]bool)
+	for _, d := range domains {
+		for _, n := range members[d] {
+			c.clustered[n.ID()] = true
+		}
+		cl := cluster{domain: d, nodes: members[d]}
+		if directed {
+			c.clusters = append(c.clusters, directedCluster{cl})
+		} else {
+			c.clusters = append(c.clusters, cl)
+		}
+	}
+}
+
+// Structure returns the domain clusters of g.
+func (g encodableGraph) Structure() []dot.Multigraph {
+	return g.clusters
+}
+
+// Nodes returns the nodes of g. Nodes that are clustered are
+// returned without attributes, since they are defined in their
+// cluster.
+func (g encodableGraph) Nodes() graph.Nodes {
+	if g.clustered == nil {
+		return g.addrGraph.Nodes()
+	}
+	nodes := graph.NodesOf(g.addrGraph.Nodes())
+	for i, n := range nodes {
+		if g.clustered[n.ID()] {
+			nodes[i] = clusteredNode{Node: n, id: n.(person).DOTID()}
+		}
+	}
+	return iterator.NewOrderedNodes(nodes)
+}
+
+// clusteredNode is a node that is defined in a cluster.
+type clusteredNode struct {
+	graph.Node
+	id string
+}
+
+func (n clusteredNode) DOTID() string { return n.id }
+
+// cluster is a DOT cluster holding the nodes of a domain
+// without any edges.
+type cluster struct {
+	domain string
+	nodes  []graph.Node
+}
+
+func (c cluster) DOTID() string { return "cluster_" + c.domain }
+
+func (c cluster) DOTAttributers() (graph, node, edge encoding.Attributer) {
+	return attributes{{Key: "label", Value: fmt.Sprintf("%q", c.domain)}}, nil, nil
+}
+
+func (c cluster) Node(id int64) graph.Node {
+	for _, n := range c.nodes {
+		if n.ID() == id {
+			return n
+		}
+	}
+	return nil
+}
+
+func (c cluster) Nodes() graph.Nodes                 { return iterator.NewOrderedNodes(c.nodes) }
+func (c cluster) From(id int64) graph.Nodes          { return graph.Empty }
+func (c cluster) HasEdgeBetween(xid, yid int64) bool { return false }
+func (c cluster) Edge(uid, vid int64) graph.Edge     { return nil }
+func (c cluster) Lines(uid, vid int64) graph.Lines   { return graph.Empty }
+
+// directedCluster is a cluster of a directed graph.
+type directedCluster struct {
+	cluster
+}
+
+func (c directedCluster) HasEdgeFromTo(uid, vid int64) bool { return false }
+func (c directedCluster) To(id int64) graph.Nodes           { return graph.Empty }
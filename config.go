@@ -0,0 +1,97 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// setDefaults sets the flags of fs that were not given on the
+// command line from the MBG_* environment variables and from the
+// config file at path, in that order of precedence. If the config
+// flag was not given, the path is taken from MBG_CONFIG if it is
+// set. If path is empty no config file is read.
+//
+// Each line of the config file holds a flag name, without its
+// leading dash, and its value separated by an equals sign. Blank
+// lines and lines starting with # are ignored. The environment
+// variable for a flag is its name in upper case with dashes replaced
+// by underscores and prefixed with MBG_, so -exclude-domain is set
+// by MBG_EXCLUDE_DOMAIN.
+func setDefaults(fs *flag.FlagSet, path string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if p, ok := os.LookupEnv(envName("config")); ok && !set["config"] {
+		path = p
+	}
+	if path != "" {
+		err := readConfig(fs, path, set)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		name := envName(f.Name)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if e := fs.Set(f.Name, val); e != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", val, name, e)
+		}
+	})
+	return err
+}
+
+// readConfig sets the flags of fs that are not in set from the
+// config file at path.
+func readConfig(fs *flag.FlagSet, path string, set map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return fmt.Errorf("%s:%d: missing = in %q", path, n, line)
+		}
+		name := strings.TrimSpace(line[:i])
+		val := strings.TrimSpace(line[i+1:])
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s:%d: unknown flag %q", path, n, name)
+		}
+		if set[name] {
+			continue
+		}
+		err = fs.Set(name, val)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for %s: %v", path, n, val, name, err)
+		}
+	}
+	return sc.Err()
+}
+
+// envName returns the name of the environment variable that sets
+// the flag with the given name.
+func envName(flag string) string {
+	return "MBG_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
@@ -0,0 +1,217 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package contact
+
+import (
+	"io"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// wordDecoder decodes RFC 2047 encoded words. Text in charsets
+// that mime.WordDecoder does not support is decoded as ISO-8859-1
+// so that an unknown charset does not lose the addresses in a
+// header.
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		b, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return strings.NewReader(string(r)), nil
+	},
+}
+
+// addrParser parses address lists using wordDecoder.
+var addrParser = &mail.AddressParser{WordDecoder: wordDecoder}
+
+// ParseAddressList returns the addresses in the address list s.
+// If s cannot be parsed as a whole, the addresses in it that can
+// be parsed individually are returned so that one malformed
+// address does not lose the others. If bad is not nil, it is
+// called with each address that cannot be parsed and the error
+// from parsing it.
+func ParseAddressList(s string, bad func(addr string, err error)) []*mail.Address {
+	list, err := addrParser.ParseList(s)
+	if err == nil {
+		return list
+	}
+	var addrs []*mail.Address
+	for _, f := range splitAddrList(s) {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		a, err := addrParser.Parse(f)
+		if err != nil {
+			if bad != nil {
+				bad(f, err)
+			}
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+// splitAddrList splits the address list s at commas that are not
+// within a quoted string, a comment or angle brackets. Group names
+// and their terminating semicolons are removed.
+func splitAddrList(s string) []string {
+	var (
+		parts   []string
+		quoted  bool
+		escaped bool
+		comment int
+		angle   bool
+		start   int
+	)
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && (quoted || comment > 0):
+			escaped = true
+		case comment > 0:
+			// Comments nest, and quotes within
+			// them are not special.
+			switch c {
+			case '(':
+				comment++
+			case ')':
+				comment--
+			}
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			comment++
+		case c == '<':
+			angle = true
+		case c == '>':
+			angle = false
+		case angle:
+		case c == ':':
+			// The text so far is a group name.
+			start = i + 1
+		case c == ',' || c == ';':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// DecodeWords returns s with any RFC 2047 encoded words decoded.
+// If s cannot be decoded it is returned unaltered.
+func DecodeWords(s string) string {
+	if !strings.Contains(s, "=?") {
+		return s
+	}
+	d, err := wordDecoder.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return d
+}
+
+// FoldAddr returns addr lowercased, or if caseSensitiveLocal is true,
+// with only the domain lowercased.
+func FoldAddr(addr string, caseSensitiveLocal bool) string {
+	if !caseSensitiveLocal {
+		return strings.ToLower(addr)
+	}
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return addr
+	}
+	return addr[:i+1] + strings.ToLower(addr[i+1:])
+}
+
+// NormalizeGmail returns the canonical form of a Gmail address with a
+// lowercased domain, with the local part lowercased, any +tag suffix
+// and all dots removed from it and the googlemail.com domain replaced
+// with gmail.com. Other addresses are returned unaltered.
+func NormalizeGmail(addr string) string {
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return addr
+	}
+	local, domain := addr[:i], addr[i+1:]
+	if domain != "gmail.com" && domain != "googlemail.com" {
+		return addr
+	}
+	if j := strings.Index(local, "+"); j >= 0 {
+		local = local[:j]
+	}
+	return strings.Replace(strings.ToLower(local), ".", "", -1) + "@gmail.com"
+}
+
+// automated holds patterns matching the local part of addresses
+// that are used by automated senders.
+var automated = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{name: "no-reply", re: regexp.MustCompile(`^(do-?not-?|no-?)reply([+\-_.].*)?$`)},
+	{name: "mailer-daemon", re: regexp.MustCompile(`^mailer-daemon$`)},
+	{name: "postmaster", re: regexp.MustCompile(`^postmaster$`)},
+	{name: "bounce", re: regexp.MustCompile(`^bounces?([+\-=_.].*)?$`)},
+}
+
+// Automated returns the name of the automated sender pattern
+// matching the local part of addr, and whether any pattern
+// matched. The patterns match no-reply, mailer-daemon, postmaster
+// and bounce addresses.
+func Automated(addr string) (pattern string, ok bool) {
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return "", false
+	}
+	local := strings.ToLower(addr[:i])
+	for _, p := range automated {
+		if p.re.MatchString(local) {
+			return p.name, true
+		}
+	}
+	return "", false
+}
+
+// MessageDate returns the date of the message with the header h and
+// the source of the date. The date is taken from the first Date
+// header that can be parsed, or failing that from the time stamp
+// after the last semicolon of the first Received header that can be
+// parsed, or from the envelope date if it is not zero. The error
+// from the Date header is returned if no date is found.
+func MessageDate(h mail.Header, envelope time.Time) (date time.Time, source string, err error) {
+	err = mail.ErrHeaderNotPresent
+	for _, v := range h["Date"] {
+		date, err = mail.ParseDate(v)
+		if err == nil {
+			return date, "date", nil
+		}
+	}
+	for _, v := range h["Received"] {
+		i := strings.LastIndex(v, ";")
+		if i < 0 {
+			continue
+		}
+		date, rerr := mail.ParseDate(strings.TrimSpace(v[i+1:]))
+		if rerr == nil {
+			return date, "received", nil
+		}
+	}
+	if !envelope.IsZero() {
+		return envelope, "envelope", nil
+	}
+	return time.Time{}, "", err
+}
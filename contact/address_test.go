@@ -0,0 +1,59 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package contact
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeWords(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{in: "plain subject", want: "plain subject"},
+		{in: "=?UTF-8?B?SsO8cmdlbiBHcm/Dnw==?=", want: "Jürgen Groß"},
+		{in: "Re: =?UTF-8?Q?J=C3=BCrgen_Gro=C3=9F?=", want: "Re: Jürgen Groß"},
+		{in: "=?UTF-8?X?invalid?=", want: "=?UTF-8?X?invalid?="},
+	} {
+		got := DecodeWords(test.in)
+		if got != test.want {
+			t.Errorf("unexpected decoding of %q: got:%q want:%q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	for _, test := range []struct {
+		list    string
+		want    []string
+		wantBad []string
+	}{
+		{
+			list: `"Doe, Jane" <jane@example.com>, bob@example.org`,
+			want: []string{"jane@example.com", "bob@example.org"},
+		},
+		{
+			list: `team: jane@example.com, bob@example.org;`,
+			want: []string{"jane@example.com", "bob@example.org"},
+		},
+		{
+			list:    `"Doe, Jane" <jane@example.com>, bob@example.org, <broken`,
+			want:    []string{"jane@example.com", "bob@example.org"},
+			wantBad: []string{"<broken"},
+		},
+	} {
+		var got, bad []string
+		for _, a := range ParseAddressList(test.list, func(addr string, _ error) { bad = append(bad, addr) }) {
+			got = append(got, a.Address)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected addresses for %q: got:%q want:%q", test.list, got, test.want)
+		}
+		if !reflect.DeepEqual(bad, test.wantBad) {
+			t.Errorf("unexpected unparsed addresses for %q: got:%q want:%q", test.list, bad, test.wantBad)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package contact builds graphs of the contacts between email
+// addresses from message headers.
+//
+// Each message adds a line between each pair of the addresses
+// in its originator and recipient headers, or in a directed
+// graph, from each originator to each recipient. The weight of
+// an edge is calculated from the messages it holds.
+package contact
+
+import (
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// Headers is an iterator over message headers.
+type Headers interface {
+	// Next advances the iterator and returns
+	// whether there is another header.
+	Next() bool
+
+	// Header returns the current header.
+	Header() *mail.Header
+
+	// Err returns the first error encountered
+	// by the iterator.
+	Err() error
+}
+
+// NewHeaders returns a Headers iterator over the given headers.
+func NewHeaders(headers ...*mail.Header) Headers {
+	return &headerSlice{headers: headers, i: -1}
+}
+
+// headerSlice is a Headers iterator over a slice of headers.
+type headerSlice struct {
+	headers []*mail.Header
+	i       int
+}
+
+func (s *headerSlice) Next() bool {
+	if s.i < len(s.headers) {
+		s.i++
+	}
+	return s.i < len(s.headers)
+}
+
+func (s *headerSlice) Header() *mail.Header {
+	if s.i < 0 || s.i >= len(s.headers) {
+		return nil
+	}
+	return s.headers[s.i]
+}
+
+func (s *headerSlice) Err() error { return nil }
+
+// Options holds the configuration of graph construction. The
+// zero value builds an undirected graph weighted by message
+// count from the From, To, Cc and Bcc headers.
+type Options struct {
+	// Directed specifies that lines are directed
+	// from the originators to the recipients of
+	// each message.
+	Directed bool
+
+	// Weight is the edge weight function. If it
+	// is nil, MessageCount is used.
+	Weight WeightFunc
+
+	// Originators and Recipients are the names
+	// of the headers holding the addresses of
+	// the senders and recipients of a message.
+	// If both are empty, From is used for the
+	// originators and To, Cc and Bcc for the
+	// recipients.
+	Originators []string
+	Recipients  []string
+
+	// CaseSensitiveLocal specifies that the local
+	// part of addresses is not case folded.
+	CaseSensitiveLocal bool
+
+	// NormalizeGmail specifies that Gmail addresses
+	// are canonicalized by NormalizeGmail.
+	NormalizeGmail bool
+
+	// Aliases maps case folded address aliases
+	// to their canonical address.
+	Aliases map[string]string
+
+	// Include and Exclude are patterns matched
+	// against canonical addresses. If Include
+	// is not nil, only matching addresses are
+	// included. Addresses matching Exclude are
+	// never included.
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+
+	// IgnoreAutomated specifies that the addresses
+	// of automated senders, as identified by
+	// Automated, are not included.
+	IgnoreAutomated bool
+
+	// ByDomain specifies that the nodes of the
+	// graph are the domains of addresses rather
+	// than the addresses.
+	ByDomain bool
+
+	// Since and Until are the inclusive bounds
+	// of the dates of messages to include if they
+	// are not zero. Messages without a date are
+	// excluded if either bound is set.
+	Since, Until time.Time
+
+	// SelfLoops specifies that an address that
+	// appears more than once on a message, or
+	// sends a message to itself, is given a
+	// line to itself.
+	SelfLoops bool
+}
+
+// Build returns the contact graph of the messages with the headers
+// in headers, constructed according to opts. The returned graph is
+// a Graph, or a DirectedGraph if opts.Directed is true. Messages
+// with fewer than two addresses after filtering are ignored. The
+// error returned by headers.Err is returned.
+func Build(headers Headers, opts Options) (graph.Weighted, error) {
+	if len(opts.Originators) == 0 && len(opts.Recipients) == 0 {
+		opts.Originators = []string{"from"}
+		opts.Recipients = []string{"to", "cc", "bcc"}
+	}
+	if opts.Weight == nil {
+		opts.Weight = MessageCount
+	}
+	g := newGraph(opts.Directed, opts.Weight)
+	for headers.Next() {
+		add(g, *headers.Header(), opts)
+	}
+	if opts.Directed {
+		return DirectedGraph{g}, headers.Err()
+	}
+	return g, headers.Err()
+}
+
+// address is an address extracted from a message header.
+type address struct {
+	// name is the decoded display name
+	// for the address.
+	name string
+
+	// addr is the canonical form of the
+	// address.
+	addr string
+}
+
+// add adds lines for the message with the header h to g.
+func add(g Graph, h mail.Header, opts Options) {
+	date, _, _ := MessageDate(h, time.Time{})
+	if !inWindow(date, opts.Since, opts.Until) {
+		return
+	}
+	from := extractAddrs(nil, h, opts.Originators, opts)
+	found := extractAddrs(from, h, opts.Recipients, opts)
+	if len(found) < 2 {
+		return
+	}
+
+	addrs := make([]string, len(found))
+	for i, a := range found {
+		addrs[i] = a.addr
+	}
+	k := len(dedup(append([]string(nil), addrs...)))
+	mid := strings.TrimSpace(h.Get("message-id"))
+	subject := DecodeWords(h.Get("subject"))
+	addLine := func(p, q string) {
+		u, v := g.person(p), g.person(q)
+		g.SetLine(Message{
+			Line:         g.NewLine(u, v),
+			Date:         date,
+			MessageID:    mid,
+			Subject:      subject,
+			Participants: k,
+		})
+	}
+
+	var n int
+	if opts.Directed {
+		senders, recipients := dedup(addrs[:len(from)]), dedup(addrs[len(from):])
+		for _, p := range senders {
+			for _, q := range recipients {
+				if p == q && !opts.SelfLoops {
+					continue
+				}
+				addLine(p, q)
+				n++
+			}
+		}
+	} else {
+		if opts.SelfLoops {
+			for _, p := range repeated(addrs) {
+				addLine(p, p)
+				n++
+			}
+		}
+		addrs = dedup(addrs)
+		for i, p := range addrs {
+			for _, q := range addrs[i+1:] {
+				addLine(p, q)
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return
+	}
+	for _, a := range found {
+		if a.name == "" {
+			continue
+		}
+		if p, ok := g.Person(a.addr); ok {
+			p.Names[a.name]++
+		}
+	}
+}
+
+// extractAddrs appends the addresses in all the tags headers of h
+// to dst, canonicalized and filtered according to opts.
+func extractAddrs(dst []address, h mail.Header, tags []string, opts Options) []address {
+	for _, tag := range tags {
+		for _, v := range h[textproto.CanonicalMIMEHeaderKey(tag)] {
+			for _, a := range ParseAddressList(v, nil) {
+				addr := FoldAddr(a.Address, opts.CaseSensitiveLocal)
+				if opts.NormalizeGmail {
+					addr = NormalizeGmail(addr)
+				}
+				if canon, ok := opts.Aliases[addr]; ok {
+					addr = canon
+				}
+				if opts.Include != nil && !opts.Include.MatchString(addr) {
+					continue
+				}
+				if opts.Exclude != nil && opts.Exclude.MatchString(addr) {
+					continue
+				}
+				if opts.IgnoreAutomated {
+					if _, ok := Automated(addr); ok {
+						continue
+					}
+				}
+				if opts.ByDomain {
+					i := strings.LastIndex(addr, "@")
+					if i < 0 {
+						continue
+					}
+					dst = append(dst, address{addr: addr[i+1:]})
+					continue
+				}
+				dst = append(dst, address{name: DecodeWords(a.Name), addr: addr})
+			}
+		}
+	}
+	return dst
+}
+
+// inWindow returns whether date is within the inclusive window
+// from since to until. Zero bounds are unset. Zero dates are
+// outside the window if either bound is set.
+func inWindow(date, since, until time.Time) bool {
+	if since.IsZero() && until.IsZero() {
+		return true
+	}
+	if date.IsZero() {
+		return false
+	}
+	return (since.IsZero() || !date.Before(since)) && (until.IsZero() || !date.After(until))
+}
+
+// dedup returns addrs with duplicates removed. The order of
+// addrs is not retained.
+func dedup(addrs []string) []string {
+	if len(addrs) < 2 {
+		return addrs
+	}
+	sort.Strings(addrs)
+	n := 1
+	for _, a := range addrs[1:] {
+		if a != addrs[n-1] {
+			addrs[n] = a
+			n++
+		}
+	}
+	return addrs[:n]
+}
+
+// repeated returns the addresses that appear more than once in
+// addrs, in sorted order.
+func repeated(addrs []string) []string {
+	var rep []string
+	seen := make(map[string]int)
+	for _, a := range addrs {
+		seen[a]++
+		if seen[a] == 2 {
+			rep = append(rep, a)
+		}
+	}
+	sort.Strings(rep)
+	return rep
+}
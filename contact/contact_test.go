@@ -0,0 +1,176 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package contact
+
+import (
+	"net/mail"
+	"regexp"
+	"testing"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+var testHeaders = []mail.Header{
+	{
+		"From":       {`Alice <alice@example.com>`},
+		"To":         {`bob@example.com, Carol <CAROL@example.com>`},
+		"Date":       {"Mon, 2 Jan 2006 15:04:05 -0700"},
+		"Message-Id": {"<1@example.com>"},
+	},
+	{
+		"From":       {`bob@example.com`},
+		"To":         {`alice@example.com`},
+		"Date":       {"Tue, 3 Jan 2006 15:04:05 -0700"},
+		"Message-Id": {"<2@example.com>"},
+	},
+	{
+		"From":       {`noreply@example.com`},
+		"To":         {`alice@example.com`},
+		"Date":       {"Wed, 4 Jan 2006 15:04:05 -0700"},
+		"Message-Id": {"<3@example.com>"},
+	},
+}
+
+func headers() Headers {
+	h := make([]*mail.Header, len(testHeaders))
+	for i := range testHeaders {
+		h[i] = &testHeaders[i]
+	}
+	return NewHeaders(h...)
+}
+
+type weightTest struct {
+	u, v string
+	want float64
+}
+
+var buildTests = []struct {
+	name  string
+	opts  Options
+	nodes int
+	edges []weightTest
+}{
+	{
+		name:  "undirected",
+		nodes: 4,
+		edges: []weightTest{
+			{u: "alice@example.com", v: "bob@example.com", want: 2},
+			{u: "bob@example.com", v: "alice@example.com", want: 2},
+			{u: "alice@example.com", v: "carol@example.com", want: 1},
+			{u: "bob@example.com", v: "carol@example.com", want: 1},
+			{u: "alice@example.com", v: "noreply@example.com", want: 1},
+		},
+	},
+	{
+		name:  "directed",
+		opts:  Options{Directed: true},
+		nodes: 4,
+		edges: []weightTest{
+			{u: "alice@example.com", v: "bob@example.com", want: 1},
+			{u: "bob@example.com", v: "alice@example.com", want: 1},
+			{u: "alice@example.com", v: "carol@example.com", want: 1},
+			{u: "carol@example.com", v: "alice@example.com", want: 0},
+			{u: "bob@example.com", v: "carol@example.com", want: 0},
+		},
+	},
+	{
+		name:  "ignore_automated",
+		opts:  Options{IgnoreAutomated: true},
+		nodes: 3,
+		edges: []weightTest{
+			{u: "alice@example.com", v: "bob@example.com", want: 2},
+		},
+	},
+	{
+		name:  "exclude",
+		opts:  Options{Exclude: regexp.MustCompile(`^carol@`)},
+		nodes: 3,
+		edges: []weightTest{
+			{u: "alice@example.com", v: "bob@example.com", want: 2},
+			{u: "alice@example.com", v: "noreply@example.com", want: 1},
+		},
+	},
+	{
+		name:  "recipient_share",
+		opts:  Options{Weight: RecipientShare},
+		nodes: 4,
+		edges: []weightTest{
+			{u: "alice@example.com", v: "bob@example.com", want: 1.5},
+			{u: "alice@example.com", v: "noreply@example.com", want: 1},
+		},
+	},
+	{
+		name:  "by_domain",
+		opts:  Options{ByDomain: true, SelfLoops: true},
+		nodes: 1,
+		edges: []weightTest{
+			{u: "example.com", v: "example.com", want: 3},
+		},
+	},
+}
+
+func TestBuild(t *testing.T) {
+	for _, test := range buildTests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := Build(headers(), test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, directed := g.(graph.Directed)
+			if directed != test.opts.Directed {
+				t.Errorf("unexpected directedness: got:%t want:%t", directed, test.opts.Directed)
+			}
+			var cg Graph
+			switch g := g.(type) {
+			case Graph:
+				cg = g
+			case DirectedGraph:
+				cg = g.Graph
+			default:
+				t.Fatalf("unexpected graph type: %T", g)
+			}
+			if n := g.Nodes().Len(); n != test.nodes {
+				t.Errorf("unexpected number of nodes: got:%d want:%d", n, test.nodes)
+			}
+			for _, e := range test.edges {
+				u, ok := cg.Person(e.u)
+				if !ok {
+					t.Errorf("missing node for %s", e.u)
+					continue
+				}
+				v, ok := cg.Person(e.v)
+				if !ok {
+					t.Errorf("missing node for %s", e.v)
+					continue
+				}
+				w, _ := g.Weight(u.ID(), v.ID())
+				if w != e.want {
+					t.Errorf("unexpected weight for %s--%s: got:%v want:%v", e.u, e.v, w, e.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildNames(t *testing.T) {
+	g, err := Build(headers(), Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for addr, want := range map[string]string{
+		"alice@example.com": "Alice",
+		"bob@example.com":   "bob@example.com",
+		"carol@example.com": "Carol",
+	} {
+		p, ok := g.(Graph).Person(addr)
+		if !ok {
+			t.Errorf("missing node for %s", addr)
+			continue
+		}
+		if got := p.Name(); got != want {
+			t.Errorf("unexpected name for %s: got:%q want:%q", addr, got, want)
+		}
+	}
+}
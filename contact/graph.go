@@ -0,0 +1,203 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package contact
+
+import (
+	"time"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/multi"
+)
+
+// Graph is a multigraph of the contacts between addresses. Its
+// nodes are Person values and its lines are Message values, with
+// one line for each message between a pair of addresses. The
+// weight of an edge is calculated from its lines by the weight
+// function of the graph.
+type Graph struct {
+	multigraph
+
+	id     map[string]int64
+	weight WeightFunc
+}
+
+// multigraph is the graph behaviour required by Graph. It
+// is satisfied by *multi.UndirectedGraph and *multi.DirectedGraph.
+type multigraph interface {
+	graph.Multigraph
+	graph.NodeAdder
+	graph.LineAdder
+}
+
+// newGraph returns a new Graph, holding a directed multigraph if
+// directed is true, that weights edges with the weight function.
+func newGraph(directed bool, weight WeightFunc) Graph {
+	if directed {
+		return Graph{multi.NewDirectedGraph(), make(map[string]int64), weight}
+	}
+	return Graph{multi.NewUndirectedGraph(), make(map[string]int64), weight}
+}
+
+// Graph will report edge weights based on line connections
+// between nodes.
+var _ graph.Weighted = Graph{}
+
+// Person returns the node for the address addr and whether it
+// is in the graph.
+func (g Graph) Person(addr string) (Person, bool) {
+	id, ok := g.id[addr]
+	if !ok {
+		return Person{}, false
+	}
+	return g.Node(id).(Person), true
+}
+
+// person returns the node for the address addr, adding it to the
+// graph if it does not already exist.
+func (g Graph) person(addr string) Person {
+	id, ok := g.id[addr]
+	if ok {
+		return g.Node(id).(Person)
+	}
+	p := Person{Node: g.NewNode(), Addr: addr, Names: make(map[string]int)}
+	g.AddNode(p)
+	g.id[addr] = p.ID()
+	return p
+}
+
+func (g Graph) Edge(xid, yid int64) graph.Edge {
+	return g.WeightedEdge(xid, yid)
+}
+
+func (g Graph) WeightedEdge(xid, yid int64) graph.WeightedEdge {
+	e := g.Lines(xid, yid)
+	if e == graph.Empty {
+		return nil
+	}
+	return Edge{multi.Edge{F: g.Node(xid), T: g.Node(yid), Lines: e}, g.weight}
+}
+
+func (g Graph) Weight(xid, yid int64) (float64, bool) {
+	e := g.Lines(xid, yid)
+	if e == graph.Empty {
+		return 0, false
+	}
+	return g.weight(e), true
+}
+
+// DirectedGraph is a Graph whose lines are directed from the
+// originators to the recipients of each message.
+type DirectedGraph struct {
+	Graph
+}
+
+var _ graph.WeightedDirected = DirectedGraph{}
+
+func (g DirectedGraph) HasEdgeFromTo(uid, vid int64) bool {
+	return g.multigraph.(graph.Directed).HasEdgeFromTo(uid, vid)
+}
+
+func (g DirectedGraph) To(id int64) graph.Nodes {
+	return g.multigraph.(graph.Directed).To(id)
+}
+
+// Person is a node of a Graph representing an address.
+type Person struct {
+	graph.Node
+
+	// Addr is the canonical form of
+	// the address.
+	Addr string
+
+	// Names holds the number of times each
+	// display name was seen with Addr.
+	Names map[string]int
+}
+
+// Name returns the display name most frequently seen for the
+// person, or the address if no name has been seen. Ties are
+// broken by lexical order.
+func (p Person) Name() string {
+	var name string
+	var max int
+	for s, c := range p.Names {
+		if c > max || (c == max && s < name) {
+			name = s
+			max = c
+		}
+	}
+	if name == "" {
+		return p.Addr
+	}
+	return name
+}
+
+// Message is a line of a Graph representing a message between
+// two addresses.
+type Message struct {
+	graph.Line
+
+	// Date is the date of the message, or
+	// zero if it has none.
+	Date time.Time
+
+	// MessageID is the Message-Id of the
+	// message.
+	MessageID string
+
+	// Subject is the decoded subject of
+	// the message.
+	Subject string
+
+	// Participants is the number of distinct
+	// addresses on the message.
+	Participants int
+}
+
+// ReversedLine returns a message with the line's end
+// points reversed.
+func (m Message) ReversedLine() graph.Line {
+	m.Line = m.Line.ReversedLine()
+	return m
+}
+
+// Edge is an edge of a Graph holding the messages between a
+// pair of addresses.
+type Edge struct {
+	multi.Edge
+
+	weight WeightFunc
+}
+
+// Weight returns the weight of the edge.
+func (e Edge) Weight() float64 { return e.weight(e.Lines) }
+
+// WeightFunc returns an edge weight calculated from the lines
+// of the edge. A WeightFunc must reset lines before returning.
+type WeightFunc func(lines graph.Lines) float64
+
+// MessageCount returns the number of messages in lines.
+func MessageCount(lines graph.Lines) float64 {
+	return float64(lines.Len())
+}
+
+// RecipientShare returns the sum over the messages in lines of
+// 1/(k-1) where k is the number of distinct addresses on the
+// message, so that a message to many recipients contributes
+// little to each pair of its addresses. Messages with fewer
+// than two addresses contribute one.
+func RecipientShare(lines graph.Lines) float64 {
+	var w float64
+	for lines.Next() {
+		k := lines.Line().(Message).Participants
+		if k < 2 {
+			w++
+			continue
+		}
+		w += 1 / float64(k-1)
+	}
+	lines.Reset()
+	return w
+}
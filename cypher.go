@@ -0,0 +1,93 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// marshalCypher writes g to dst as Cypher statements that merge a
+// Person node for each address and a CONTACT relationship for each
+// edge holding its weight and the first and last dates of its
+// messages. Relationships are directed if g is directed. If batch is
+// greater than zero, nodes and edges are merged with UNWIND in
+// statements of up to batch elements, otherwise each is merged by its
+// own statement.
+func marshalCypher(dst io.Writer, g addrGraph, batch int) error {
+	w := bufio.NewWriter(dst)
+
+	people := g.sortedPeople()
+	if batch > 0 {
+		for i := 0; i < len(people); i += batch {
+			end := i + batch
+			if end > len(people) {
+				end = len(people)
+			}
+			fmt.Fprint(w, "UNWIND [")
+			for j, p := range people[i:end] {
+				if j != 0 {
+					fmt.Fprint(w, ", ")
+				}
+				fmt.Fprint(w, cypherString(p.addr))
+			}
+			fmt.Fprintln(w, "] AS addr MERGE (:Person {addr: addr});")
+		}
+	} else {
+		for _, p := range people {
+			fmt.Fprintf(w, "MERGE (:Person {addr: %s});\n", cypherString(p.addr))
+		}
+	}
+
+	rel := "-"
+	if g.isDirected() {
+		rel = "->"
+	}
+	edges := g.sortedEdges()
+	if batch > 0 {
+		for i := 0; i < len(edges); i += batch {
+			end := i + batch
+			if end > len(edges) {
+				end = len(edges)
+			}
+			fmt.Fprint(w, "UNWIND [")
+			for j, e := range edges[i:end] {
+				if j != 0 {
+					fmt.Fprint(w, ", ")
+				}
+				sd, ed := edge{e, g.weight}.span()
+				fmt.Fprintf(w, "{from: %s, to: %s, weight: %v, first: %s, last: %s}",
+					cypherString(e.F.(person).addr), cypherString(e.T.(person).addr), g.weight(e.Lines), cypherDate(sd), cypherDate(ed))
+			}
+			fmt.Fprintf(w, "] AS e MATCH (a:Person {addr: e.from}), (b:Person {addr: e.to}) MERGE (a)-[r:CONTACT]%s(b) SET r.weight = e.weight, r.first = e.first, r.last = e.last;\n", rel)
+		}
+	} else {
+		for _, e := range edges {
+			sd, ed := edge{e, g.weight}.span()
+			fmt.Fprintf(w, "MATCH (a:Person {addr: %s}), (b:Person {addr: %s}) MERGE (a)-[r:CONTACT]%s(b) SET r.weight = %v, r.first = %s, r.last = %s;\n",
+				cypherString(e.F.(person).addr), cypherString(e.T.(person).addr), rel, g.weight(e.Lines), cypherDate(sd), cypherDate(ed))
+		}
+	}
+	return w.Flush()
+}
+
+// cypherQuoter escapes the characters of Cypher string literals.
+var cypherQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`)
+
+// cypherString returns s as a Cypher string literal.
+func cypherString(s string) string {
+	return "'" + cypherQuoter.Replace(s) + "'"
+}
+
+// cypherDate returns t as a Cypher datetime, or null if t is zero.
+func cypherDate(t time.Time) string {
+	if t.IsZero() {
+		return "null"
+	}
+	return fmt.Sprintf("datetime('%s')", t.Format(time.RFC3339))
+}
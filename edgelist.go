@@ -0,0 +1,28 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// marshalEdgeList writes g to dst as a list of edges, one per
+// line, holding the addresses of the end points and the edge
+// weight separated by delim. Edges are written in the order of
+// the addresses of their end points.
+func marshalEdgeList(dst io.Writer, g addrGraph, delim string) error {
+	w := bufio.NewWriter(dst)
+	for _, e := range g.sortedEdges() {
+		u, v := e.From(), e.To()
+		weight, _ := g.Weight(u.ID(), v.ID())
+		_, err := fmt.Fprintf(w, "%s%s%s%s%v\n", u.(person).addr, delim, v.(person).addr, delim, weight)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
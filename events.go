@@ -0,0 +1,133 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// event is a single line between two addresses in the JSON lines
+// event stream. It holds the properties of the message needed to
+// reconstruct the line when the events are loaded.
+type event struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Date    string `json:"date,omitempty"`
+	MID     string `json:"mid,omitempty"`
+	Subject string `json:"subject,omitempty"`
+
+	// Participants is the number of distinct
+	// addresses on the message.
+	Participants int `json:"participants,omitempty"`
+
+	// Weight is the recipient header weight of
+	// the line if header weights are used.
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+// eventWriter writes lines as JSON lines events.
+type eventWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+
+	// err is the first error encountered
+	// while writing.
+	err error
+}
+
+// newEventWriter returns an eventWriter writing to w.
+func newEventWriter(w io.Writer) *eventWriter {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	return &eventWriter{w: bw, enc: enc}
+}
+
+// write writes the event e.
+func (w *eventWriter) write(e event) {
+	if w.err != nil {
+		return
+	}
+	w.err = w.enc.Encode(e)
+}
+
+// flush writes any buffered events to the underlying writer and
+// returns the first error encountered.
+func (w *eventWriter) flush() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+// loadEvents adds the lines of the JSON lines events in the file at
+// path, which may be compressed, to the graph, or if events are being
+// written, writes them to the event stream. If b.dedup is true, the
+// message IDs of the events are marked as seen so that messages added
+// later are not counted twice. The lines are added as they are,
+// without filtering.
+func (b *builder) loadEvents(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r, err := decompressed(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var e event
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("event %d: %v", n, err)
+		}
+		if e.From == "" || e.To == "" {
+			return fmt.Errorf("event %d: missing address", n)
+		}
+		var date time.Time
+		if e.Date != "" {
+			date, err = time.Parse(time.RFC3339, e.Date)
+			if err != nil {
+				return fmt.Errorf("event %d: %v", n, err)
+			}
+		}
+		if b.dedup && e.MID != "" {
+			if b.seen == nil {
+				b.seen = make(map[string]bool)
+			}
+			b.seen[e.MID] = true
+		}
+
+		b.stats.count(&b.stats.lines)
+		if b.events != nil {
+			b.events.write(e)
+			continue
+		}
+		g := b.graph(date)
+		l := g.message(g.person(e.From), g.person(e.To), date, e.MID)
+		l.subject = e.Subject
+		l.participants = e.Participants
+		l.factor = 1
+		if e.Weight != nil {
+			l.factor = *e.Weight
+		}
+		g.SetLine(l)
+	}
+	if b.events != nil {
+		return b.events.flush()
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// writeGephiCSV writes g to the files base.nodes.csv and
+// base.edges.csv as Gephi data laboratory node and edge tables.
+func writeGephiCSV(base string, g addrGraph) error {
+	for _, table := range []struct {
+		path    string
+		marshal func(io.Writer, addrGraph) error
+	}{
+		{path: base + ".nodes.csv", marshal: marshalGephiNodes},
+		{path: base + ".edges.csv", marshal: marshalGephiEdges},
+	} {
+		f, err := os.Create(table.path)
+		if err != nil {
+			return err
+		}
+		err = table.marshal(f, g)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalGephiNodes writes the nodes of g to dst as a Gephi node
+// table holding the node ID, name and measured attributes. Columns
+// for attributes that are only measured in some modes are written
+// if any node has them. Nodes are written in address order.
+func marshalGephiNodes(dst io.Writer, g addrGraph) error {
+	people := g.sortedPeople()
+	var dates, messages, betweenness, communities, kinds bool
+	for _, p := range people {
+		dates = dates || !p.attrs.first.IsZero()
+		messages = messages || p.attrs.hasMessages
+		betweenness = betweenness || p.attrs.hasBetweenness
+		communities = communities || p.attrs.hasCommunity
+		kinds = kinds || p.attrs.kind != ""
+	}
+
+	w := csv.NewWriter(dst)
+	header := []string{"Id", "Label", "Address", "Degree", "Weighted Degree"}
+	if dates {
+		header = append(header, "First", "Last")
+	}
+	if messages {
+		header = append(header, "Sent", "Received")
+	}
+	if betweenness {
+		header = append(header, "Betweenness")
+	}
+	if communities {
+		header = append(header, "Community")
+	}
+	if kinds {
+		header = append(header, "Kind")
+	}
+	err := w.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, p := range people {
+		row := []string{
+			fmt.Sprint(p.ID()),
+			p.name(),
+			p.addr,
+			fmt.Sprint(p.attrs.degree),
+			fmt.Sprint(p.attrs.wdegree),
+		}
+		if dates {
+			var first, last string
+			if !p.attrs.first.IsZero() {
+				first = p.attrs.first.Format(dateTime)
+				last = p.attrs.last.Format(dateTime)
+			}
+			row = append(row, first, last)
+		}
+		if messages {
+			row = append(row, fmt.Sprint(p.attrs.sent), fmt.Sprint(p.attrs.received))
+		}
+		if betweenness {
+			row = append(row, fmt.Sprint(p.attrs.betweenness))
+		}
+		if communities {
+			row = append(row, fmt.Sprint(p.attrs.community))
+		}
+		if kinds {
+			row = append(row, p.attrs.kind)
+		}
+		err = w.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// marshalGephiEdges writes the edges of g to dst as a Gephi edge
+// table holding the end node IDs, weight, edge type, message count
+// and time span of each edge. Edges are written in the order of
+// the addresses of their end points.
+func marshalGephiEdges(dst io.Writer, g addrGraph) error {
+	typ := "Undirected"
+	if g.isDirected() {
+		typ = "Directed"
+	}
+
+	w := csv.NewWriter(dst)
+	err := w.Write([]string{"Source", "Target", "Weight", "Type", "Count", "Start", "End"})
+	if err != nil {
+		return err
+	}
+	for _, e := range g.sortedEdges() {
+		e := edge{e, g.weight}
+		var start, end string
+		sd, ed := e.span()
+		if !sd.IsZero() {
+			start = sd.Format(dateTime)
+			end = ed.Format(dateTime)
+		}
+		err = w.Write([]string{
+			fmt.Sprint(e.From().ID()),
+			fmt.Sprint(e.To().ID()),
+			strconv.FormatFloat(e.Weight(), 'g', -1, 64),
+			typ,
+			fmt.Sprint(e.Len()),
+			start,
+			end,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
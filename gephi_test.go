@@ -0,0 +1,61 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMarshalGephiNodes(t *testing.T) {
+	originators, recipients, _ := parseHeaders("from,to,cc,bcc")
+	g, _, err := buildGraph(strings.NewReader(testMbox), options{
+		weight:      messageCount,
+		originators: originators,
+		recipients:  recipients,
+		bufSize:     1 << 16,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.measureDegrees()
+	g.measureContacts()
+	for i, p := range g.sortedPeople() {
+		p.attrs.community = i % 2
+		p.attrs.hasCommunity = true
+	}
+
+	var buf bytes.Buffer
+	err = marshalGephiNodes(&buf, g)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read node table: %v", err)
+	}
+
+	wantHeader := []string{"Id", "Label", "Address", "Degree", "Weighted Degree", "First", "Last", "Community"}
+	if !reflect.DeepEqual(rows[0], wantHeader) {
+		t.Errorf("unexpected header: got:%q want:%q", rows[0], wantHeader)
+	}
+	var addrs []string
+	for _, row := range rows[1:] {
+		addrs = append(addrs, row[2])
+		if row[5] == "" || row[6] == "" {
+			t.Errorf("missing contact dates for %s", row[2])
+		}
+	}
+	if len(addrs) != g.Nodes().Len() {
+		t.Errorf("unexpected number of nodes: got:%d want:%d", len(addrs), g.Nodes().Len())
+	}
+	if !sort.StringsAreSorted(addrs) {
+		t.Errorf("nodes not in address order: %q", addrs)
+	}
+}
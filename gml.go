@@ -0,0 +1,61 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// marshalGML writes g to dst in the Graph Modelling Language. Nodes
+// are labelled with their address and edges carry their weight as
+// their value. Nodes and edges are written in address order.
+func marshalGML(dst io.Writer, g addrGraph) error {
+	w := bufio.NewWriter(dst)
+	fmt.Fprintln(w, "graph [")
+	if g.isDirected() {
+		fmt.Fprintln(w, "  directed 1")
+	} else {
+		fmt.Fprintln(w, "  directed 0")
+	}
+	for _, p := range g.sortedPeople() {
+		fmt.Fprintf(w, "  node [\n    id %d\n    label %s\n  ]\n", p.ID(), gmlString(p.addr))
+	}
+	for _, e := range g.sortedEdges() {
+		u, v := e.From().ID(), e.To().ID()
+		weight, _ := g.Weight(u, v)
+		_, err := fmt.Fprintf(w, "  edge [\n    source %d\n    target %d\n    value %v\n  ]\n", u, v, weight)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(w, "]")
+	return w.Flush()
+}
+
+// gmlString returns s as a quoted GML string. GML strings are
+// ISO 8859-1 and cannot contain double quotes, so quotes,
+// ampersands and characters outside 7-bit ASCII are written
+// as character entities.
+func gmlString(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"':
+			buf.WriteString("&quot;")
+		case r == '&':
+			buf.WriteString("&amp;")
+		case r > '~':
+			fmt.Fprintf(&buf, "&#%d;", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
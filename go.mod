@@ -1,10 +1,8 @@
 module github.com/kortschak/mbg
 
-go 1.12
+go 1.22
 
 require (
-	github.com/blabber/mbox v0.0.0-20181007094041-1ce958f907de
-	github.com/emersion/go-mbox v1.0.0
-	golang.org/x/exp v0.0.0-20200513190911-00229845015e // indirect
-	gonum.org/v1/gonum v0.9.3
+	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa
+	gonum.org/v1/gonum v0.15.1
 )
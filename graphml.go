@@ -0,0 +1,116 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// graphML is the root of a GraphML document.
+type graphML struct {
+	XMLName xml.Name     `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+// graphMLKey declares a data attribute.
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// marshalGraphML writes g to dst in GraphML format. Each node
+// is labelled with its address and the lines between each pair
+// of nodes are collapsed into a single edge holding the weight
+// and the date span of the messages between them.
+func marshalGraphML(dst io.Writer, g addrGraph) error {
+	c := graphML{
+		Keys: []graphMLKey{
+			{ID: "addr", For: "node", Name: "addr", Type: "string"},
+			{ID: "weight", For: "edge", Name: "weight", Type: "double"},
+			{ID: "start", For: "edge", Name: "start", Type: "string"},
+			{ID: "end", For: "edge", Name: "end", Type: "string"},
+		},
+		Graph: graphMLGraph{
+			ID:          "G",
+			EdgeDefault: edgeType(g),
+		},
+	}
+
+	var kinds bool
+	people := g.sortedPeople()
+	c.Graph.Nodes = make([]graphMLNode, 0, len(people))
+	for _, p := range people {
+		n := graphMLNode{
+			ID:   fmt.Sprint(p.ID()),
+			Data: []graphMLData{{Key: "addr", Value: p.addr}},
+		}
+		if p.attrs.kind != "" {
+			n.Data = append(n.Data, graphMLData{Key: "kind", Value: p.attrs.kind})
+			kinds = true
+		}
+		c.Graph.Nodes = append(c.Graph.Nodes, n)
+	}
+	if kinds {
+		c.Keys = append(c.Keys, graphMLKey{ID: "kind", For: "node", Name: "kind", Type: "string"})
+	}
+
+	for _, e := range g.sortedEdges() {
+		e := edge{e, g.weight}
+		l := graphMLEdge{
+			Source: fmt.Sprint(e.From().ID()),
+			Target: fmt.Sprint(e.To().ID()),
+			Data:   []graphMLData{{Key: "weight", Value: fmt.Sprint(e.Weight())}},
+		}
+		sd, ed := e.span()
+		if !sd.IsZero() {
+			l.Data = append(l.Data,
+				graphMLData{Key: "start", Value: sd.Format(dateTime)},
+				graphMLData{Key: "end", Value: ed.Format(dateTime)},
+			)
+		}
+		c.Graph.Edges = append(c.Graph.Edges, l)
+	}
+
+	_, err := io.WriteString(dst, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(dst)
+	enc.Indent("", "\t")
+	err = enc.Encode(c)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(dst, "\n")
+	return err
+}
@@ -0,0 +1,104 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// graphSONValue is a typed GraphSON v3 value.
+type graphSONValue struct {
+	Type  string      `json:"@type"`
+	Value interface{} `json:"@value"`
+}
+
+// graphSONInt64 returns v as a GraphSON Int64.
+func graphSONInt64(v int64) graphSONValue { return graphSONValue{Type: "g:Int64", Value: v} }
+
+// graphSONVertex is a vertex and its incident edges in the GraphSON
+// v3 adjacency list form read by TinkerPop's GraphSONReader.
+type graphSONVertex struct {
+	ID         graphSONValue                       `json:"id"`
+	Label      string                              `json:"label"`
+	InE        map[string][]graphSONEdge           `json:"inE,omitempty"`
+	OutE       map[string][]graphSONEdge           `json:"outE,omitempty"`
+	Properties map[string][]graphSONVertexProperty `json:"properties"`
+}
+
+type graphSONVertexProperty struct {
+	ID    graphSONValue `json:"id"`
+	Value string        `json:"value"`
+}
+
+// graphSONEdge is an edge held by one of its vertices. Edges held
+// by their out vertex have InV set and edges held by their in vertex
+// have OutV set.
+type graphSONEdge struct {
+	ID         graphSONValue            `json:"id"`
+	InV        *graphSONValue           `json:"inV,omitempty"`
+	OutV       *graphSONValue           `json:"outV,omitempty"`
+	Properties map[string]graphSONValue `json:"properties"`
+}
+
+// marshalGraphSON writes g to dst as GraphSON v3 with one vertex
+// per line. Each person vertex is labelled person and holds its
+// address and name, and each edge is labelled contact and holds
+// its weight, message count and the dates of its first and last
+// messages. Edges are from the first to the second node of each
+// edge if g is undirected.
+func marshalGraphSON(dst io.Writer, g addrGraph) error {
+	people := g.sortedPeople()
+	index := make(map[int64]int, len(people))
+	vertices := make([]graphSONVertex, len(people))
+	for i, p := range people {
+		index[p.ID()] = i
+		vertices[i] = graphSONVertex{
+			ID:    graphSONInt64(int64(i)),
+			Label: "person",
+			Properties: map[string][]graphSONVertexProperty{
+				"addr": {{ID: graphSONInt64(int64(2 * i)), Value: p.addr}},
+				"name": {{ID: graphSONInt64(int64(2*i + 1)), Value: p.name()}},
+			},
+		}
+	}
+
+	for i, e := range g.sortedEdges() {
+		u, v := index[e.F.ID()], index[e.T.ID()]
+		sd, ed := edge{e, g.weight}.span()
+		props := map[string]graphSONValue{
+			"weight": {Type: "g:Double", Value: g.weight(e.Lines)},
+			"count":  {Type: "g:Int32", Value: e.Len()},
+		}
+		if !sd.IsZero() {
+			props["first"] = graphSONValue{Type: "g:Date", Value: sd.UnixNano() / 1e6}
+			props["last"] = graphSONValue{Type: "g:Date", Value: ed.UnixNano() / 1e6}
+		}
+		uid, vid := graphSONInt64(int64(u)), graphSONInt64(int64(v))
+
+		out := &vertices[u]
+		if out.OutE == nil {
+			out.OutE = make(map[string][]graphSONEdge)
+		}
+		out.OutE["contact"] = append(out.OutE["contact"], graphSONEdge{ID: graphSONInt64(int64(i)), InV: &vid, Properties: props})
+		in := &vertices[v]
+		if in.InE == nil {
+			in.InE = make(map[string][]graphSONEdge)
+		}
+		in.InE["contact"] = append(in.InE["contact"], graphSONEdge{ID: graphSONInt64(int64(i)), OutV: &uid, Properties: props})
+	}
+
+	w := bufio.NewWriter(dst)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, v := range vertices {
+		err := enc.Encode(v)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
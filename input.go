@@ -0,0 +1,295 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// open returns a reader for the mbox at path. If path is "-",
+// the returned reader reads from standard input. Compressed
+// input is transparently decompressed. If read is not nil, the
+// number of bytes read from the file is added to it atomically.
+func open(path string, read *int64) (io.ReadCloser, error) {
+	var f io.ReadCloser
+	if path == "-" {
+		f = io.NopCloser(os.Stdin)
+	} else {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if read != nil {
+		f = readCloser{Reader: countingReader{r: f, n: read}, Closer: f}
+	}
+	return decompressed(f)
+}
+
+// decompressed returns a reader for the transparently decompressed
+// data in f that closes f when it is closed.
+func decompressed(f io.ReadCloser) (io.ReadCloser, error) {
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return readCloser{Reader: r, Closer: f}, nil
+}
+
+// source is an mbox to be read.
+type source struct {
+	// name is the name of the mbox in messages.
+	name string
+
+	// open returns a reader for the mbox.
+	open func() (io.ReadCloser, error)
+}
+
+var zipMagic = []byte("PK\x03\x04")
+
+// sources returns the mbox sources for the files at paths, and
+// any zip archives opened, which must be closed once the sources
+// have been read. Zip archives are identified by a .zip suffix or
+// their magic bytes, and are expanded to a source for each member
+// that holds an mbox, possibly compressed. Archives that cannot be
+// read are skipped.
+func (b *builder) sources(paths []string) ([]source, []io.Closer) {
+	var (
+		srcs     []source
+		archives []io.Closer
+	)
+	for _, path := range paths {
+		if !isZip(path) {
+			path := path
+			var read *int64
+			if b.progress != nil {
+				read = &b.progress.read
+			}
+			srcs = append(srcs, source{name: name(path), open: func() (io.ReadCloser, error) {
+				return open(path, read)
+			}})
+			continue
+		}
+
+		z, err := zip.OpenReader(path)
+		if err != nil {
+			slog.Warn("failed to open input", "path", path, "error", err)
+			continue
+		}
+		archives = append(archives, z)
+		for _, f := range z.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			f := f
+			open := func() (io.ReadCloser, error) {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				return decompressed(rc)
+			}
+			name := path + ":" + f.Name
+			ok, err := isMbox(open)
+			if !ok {
+				if err != nil {
+					slog.Debug("skipping archive member", "path", name, "error", err)
+				} else {
+					slog.Debug("skipping archive member: not an mbox", "path", name)
+				}
+				continue
+			}
+			srcs = append(srcs, source{name: name, open: open})
+		}
+	}
+	if b.progress != nil {
+		b.progress.total = inputSize(paths)
+	}
+	return srcs, archives
+}
+
+// inputSize returns the total size of the regular files at paths,
+// or zero if any path is not a regular file or is a zip archive.
+func inputSize(paths []string) int64 {
+	var total int64
+	for _, path := range paths {
+		if path == "-" || isZip(path) {
+			return 0
+		}
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0
+		}
+		total += fi.Size()
+	}
+	return total
+}
+
+// isZip returns whether the file at path is a zip archive.
+func isZip(path string) bool {
+	if path == "-" {
+		return false
+	}
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(zipMagic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && bytes.Equal(magic, zipMagic)
+}
+
+// isMbox returns whether the data returned by open start with
+// an mbox From_ line after any leading white space.
+func isMbox(open func() (io.ReadCloser, error)) (bool, error) {
+	r, err := open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return bytes.HasPrefix(bytes.TrimLeft(buf[:n], " \t\r\n"), []byte("From ")), nil
+}
+
+// readCloser allows a decompressing reader to close its
+// underlying file.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// decompress returns a reader that decompresses the data in r
+// if it is gzip or bzip2 compressed, determined by the magic
+// bytes at the start of the stream. Otherwise the data are
+// returned unaltered. Concatenated gzip members are read as a
+// single stream.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(bzip2Magic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(br), nil
+	default:
+		return br, nil
+	}
+}
+
+// mboxFiles returns the paths of the files under the directory dir
+// that hold an mbox, possibly compressed, such as the folders of a
+// Thunderbird profile. Thunderbird .msf index files are skipped
+// without being read.
+func mboxFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.EqualFold(filepath.Ext(path), ".msf") {
+			return nil
+		}
+		ok, err := isMbox(func() (io.ReadCloser, error) { return open(path, nil) })
+		if !ok {
+			if err != nil {
+				slog.Debug("skipping file", "path", path, "error", err)
+			}
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	return paths, err
+}
+
+// addMaildir adds the messages in the maildir at path to the
+// graph. Messages are read from cur and new directories, and
+// tmp directories are ignored. Nested maildir folders are
+// also read. Messages are counted against b.head and retained
+// in b.recent as for mbox input.
+func (b *builder) addMaildir(path string) error {
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "tmp" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Base(filepath.Dir(path)) {
+		case "cur", "new":
+		default:
+			return nil
+		}
+		if b.head > 0 {
+			if b.scanned >= b.head {
+				return filepath.SkipAll
+			}
+			b.scanned++
+		}
+		if b.recent != nil {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			b.recent.push(data, time.Time{})
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		m, err := mail.ReadMessage(f)
+		if err != nil {
+			b.stats.count(&b.stats.messages)
+			b.stats.count(&b.stats.malformed)
+			slog.Debug("failed to read message", "path", path, "error", err)
+			return nil
+		}
+		b.addMessage(m.Header, time.Time{})
+		return nil
+	})
+}
+
+// name returns the name used to refer to the input at path.
+func name(path string) string {
+	if path == "-" {
+		return "stdin"
+	}
+	return path
+}
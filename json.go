@@ -0,0 +1,69 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// d3Graph is a graph in the JSON form used by D3 force layouts.
+type d3Graph struct {
+	Nodes []d3Node `json:"nodes"`
+	Links []d3Link `json:"links"`
+}
+
+type d3Node struct {
+	ID   interface{} `json:"id"`
+	Addr string      `json:"addr"`
+	Kind string      `json:"kind,omitempty"`
+}
+
+type d3Link struct {
+	Source interface{} `json:"source"`
+	Target interface{} `json:"target"`
+	Weight float64     `json:"weight"`
+	Start  int64       `json:"start,omitempty"`
+	End    int64       `json:"end,omitempty"`
+}
+
+// marshalJSON writes g to dst as a D3 force layout JSON graph. Nodes
+// are identified by their node ID, or by their address if byAddr is
+// true. Each link holds the edge weight and the Unix time span of the
+// messages it represents.
+func marshalJSON(dst io.Writer, g addrGraph, byAddr bool) error {
+	id := func(n graph.Node) interface{} {
+		if byAddr {
+			return n.(person).addr
+		}
+		return n.ID()
+	}
+
+	var c d3Graph
+	people := g.sortedPeople()
+	c.Nodes = make([]d3Node, 0, len(people))
+	for _, p := range people {
+		c.Nodes = append(c.Nodes, d3Node{ID: id(p), Addr: p.addr, Kind: p.attrs.kind})
+	}
+
+	for _, e := range g.sortedEdges() {
+		e := edge{e, g.weight}
+		l := d3Link{
+			Source: id(e.From()),
+			Target: id(e.To()),
+			Weight: e.Weight(),
+		}
+		sd, ed := e.span()
+		if !sd.IsZero() {
+			l.Start = sd.Unix()
+			l.End = ed.Unix()
+		}
+		c.Links = append(c.Links, l)
+	}
+
+	return json.NewEncoder(dst).Encode(c)
+}
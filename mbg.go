@@ -2,174 +2,1193 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// The mbg program extracts a contact graph from an mbox file, constructing
+// The mbg program extracts a contact graph from mbox files, constructing
 // edges between addresses that appear together in From:, To:, Cc: and Bcc:
-// lists.
+// lists. The mbox files are given as arguments or found under a directory
+// given by -recursive, or read from standard input if neither is given, and
+// may be gzip or bzip2 compressed.
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
-	"net/mail"
+	"log/slog"
+	"math"
+	"net/textproto"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/emersion/go-mbox"
-
+	"github.com/kortschak/mbg/contact"
 	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/encoding"
 	"gonum.org/v1/gonum/graph/encoding/dot"
 	"gonum.org/v1/gonum/graph/formats/gexf12"
+	"gonum.org/v1/gonum/graph/iterator"
 	"gonum.org/v1/gonum/graph/multi"
 )
 
 func main() {
-	format := flag.String("format", "dot", "output format (dot or gexf)")
+	format := flag.String("format", "dot", "comma-separated output formats (dot, dot-clustered to group nodes by domain, gexf, tgexf for GEXF with a single edge between each pair of nodes holding a spell for each message, graphml, gml, edgelist, json, pajek, adjacency, mermaid, cypher, graphson, summary, svg or gephi-csv), or jsonl alone to stream an event for each line without building a graph")
+	delim := flag.String("delimiter", "\t", "field delimiter for edgelist format")
+	batchSize := flag.Int("batch-size", 1000, "number of nodes or edges merged by each UNWIND statement in cypher format (0 merges each by its own statement)")
+	jsonAddr := flag.Bool("json-addr", false, "identify nodes by address in json format")
+	hashIDs := flag.Bool("hash-ids", false, "derive node IDs from a hash of their address so they are stable between runs over different messages")
+	focus := flag.String("focus", "", "address whose contacts are the only ones written in summary format")
+	incl := flag.String("include", "", "regex for email addresses to include")
 	excl := flag.String("exclude", "", "regex for email addresses to exclude")
+	ignoreAutomated := flag.Bool("ignore-automated", false, "exclude addresses of automated senders such as noreply, mailer-daemon, postmaster and bounce addresses")
+	inclDomains := flag.String("include-domain", "", "comma-separated domains of email addresses to include (a leading dot matches subdomains)")
+	exclDomains := flag.String("exclude-domain", "", "comma-separated domains of email addresses to exclude (a leading dot matches subdomains)")
+	hubs := flag.String("exclude-if-only", "", "comma-separated hub addresses to remove from the recipients of messages, dropping messages left with fewer than two addresses")
+	exclFile := flag.String("exclude-file", "", "file of email addresses or *@domain entries to exclude, one per line")
 	drop := flag.String("drop-from", "", "regex for emails to drop on From:")
-	verbose := flag.Bool("verbose", false, "verbosely log warnings")
+	dropMIDFile := flag.String("drop-mid-file", "", "file of Message-IDs of messages to drop, one per line, also dropping their replies with -thread")
+	maxRecipients := flag.Int("max-recipients", 0, "skip messages with more than this many addresses in a recipient header (0 is no limit)")
+	truncateRecipients := flag.Bool("truncate-recipients", false, "use the first -max-recipients addresses of a recipient header instead of skipping the message")
+	headers := flag.String("headers", "from,to,cc,bcc", "comma-separated address headers to use (from, sender, reply-to, to, cc, bcc, delivered-to and x-original-to)")
+	bccMode := flag.String("bcc-mode", "clique", "how addresses only in Bcc headers are linked (clique to link them with all addresses, to-sender-only to link them only to the senders since blind recipients do not see the others, or ignore)")
+	head := flag.Int("head", 0, "read only the first this many messages of the input, before any filtering (0 reads all)")
+	tail := flag.Int("tail", 0, "read only the last this many messages of the input, before any filtering, holding them in memory until the input has been read (0 reads all)")
+	jobs := flag.Int("j", runtime.NumCPU(), "number of input files to parse concurrently")
+	buffer := flag.String("buffer", "64M", "maximum message size with optional K, M or G suffix")
+	skipOversize := flag.Bool("skip-oversize", false, "log and skip messages larger than -buffer instead of failing")
+	dialectName := flag.String("mbox-dialect", "mboxrd", "mbox dialect of the input (mboxrd, mboxo, mboxcl or mboxcl2)")
+	maildir := flag.String("maildir", "", "maildir directory to read messages from")
+	recursive := flag.String("recursive", "", "directory, such as a mail client profile, to search recursively for mbox files to read")
+	load := flag.String("load", "", "file of events written by -format jsonl to add to the graph before reading input, so a graph can be updated with new messages")
+	byDomain := flag.Bool("by-domain", false, "construct the graph between address domains")
+	crossDomain := flag.Bool("cross-domain-only", false, "only link addresses in different domains")
+	bipartite := flag.Bool("bipartite", false, "link each address of a message to the domains of its addresses instead of to the other addresses")
+	aliasFile := flag.String("alias-file", "", "file of canonical addresses each followed by a tab and its aliases, one per line")
+	normalizeGmail := flag.Bool("normalize-gmail", false, "canonicalize Gmail address aliases")
+	caseSensitiveLocal := flag.Bool("case-sensitive-local", false, "lowercase only the domain of addresses, keeping the case of the local part")
+	anonymize := flag.Bool("anonymize", false, "replace addresses with salted hashes")
+	salt := flag.String("salt", "", "salt for -anonymize (default random)")
+	output := flag.String("output", "", "output file path (default stdout)")
+	directed := flag.Bool("directed", false, "construct a directed graph from senders to recipients")
+	reciprocal := flag.Bool("reciprocal", false, "keep only edges between people who have both sent to and received from each other, combining both directions unless -directed")
+	selfLoops := flag.Bool("self-loops", false, "retain edges from an address to itself")
+	dedup := flag.Bool("dedup", false, "skip messages with a Message-ID that has already been seen")
+	thread := flag.Bool("thread", false, "link reply senders to the senders of the messages they reply to")
+	bySubject := flag.Bool("strip-subject-prefix", false, "in thread mode, thread messages without reply headers by subject without reply prefixes")
+	metric := flag.String("weight", "messages", "edge weight metric (messages, days, unique-recipients to weight each message by 1/(k-1) for k addresses, decay to weight each message by 0.5^(age/half-life) before the newest message, or inverse for the reciprocal of the message count as a distance)")
+	halfLife := new(time.Duration)
+	*halfLife = 30 * 24 * time.Hour
+	flag.Var((*durationFlag)(halfLife), "half-life", "age at which a message counts half as much for -weight decay (Go duration or days and weeks such as 7d or 2w)")
+	headerWeights := flag.String("weights", "", "comma-separated recipient header weights such as to=3,cc=1,bcc=0.5 to count each message by the header of its recipient (unlisted headers weigh 1)")
+	minWeight := flag.Float64("min-weight", 0, "remove edges with weight less than this")
+	minDegree := flag.Int("min-degree", 0, "remove nodes with fewer than this many distinct neighbors")
+	sentReceived := flag.Bool("sent-received", false, "label nodes of a directed graph with the number of messages they sent and received")
+	centrality := flag.String("centrality", "", "node centrality to measure (betweenness)")
+	slice := new(time.Duration)
+	flag.Var((*durationFlag)(slice), "slice", "write one graph per time window of this duration (Go duration or days and weeks such as 7d or 2w) to files named from -output")
+	communities := flag.Bool("communities", false, "label nodes with their Louvain community")
+	resolution := flag.Float64("resolution", 1, "modularity resolution for -communities")
+	seed := flag.Uint64("seed", 1, "random seed for -communities")
+	components := flag.Bool("components", false, "print the number and sizes of connected components to stderr")
+	largest := flag.Bool("largest-component", false, "keep only the largest connected component")
+	top := flag.Int("top", 0, "keep only this many nodes with the highest weighted degree (0 keeps all)")
+	start := flag.String("since", "", "exclude messages before this time (RFC3339, "+dateTime+", or a duration before now such as 90d)")
+	requireDate := flag.Bool("require-date", false, "skip messages without a date")
+	end := flag.String("until", "", "exclude messages after this time (as for -since, defaults to now if -since is a duration)")
+	collapse := flag.Bool("collapse", false, "write a single GEXF edge between each pair of nodes spanning the dates of their messages")
+	static := flag.Bool("static", false, "write a static GEXF graph with one weighted edge between each pair of nodes")
+	graphName := flag.String("graph-name", "", "graph name in DOT format")
+	layout := flag.String("layout", "dot", "Graphviz layout engine used to render svg format (dot, neato, fdp or sfdp)")
+	dotColor := flag.Bool("dot-color", false, "color DOT edges along a gradient by weight")
+	var dotAttrs dotAttributes
+	flag.Var(&dotAttrs.graph, "graph-attr", "graph attribute key=value in DOT format (repeatable)")
+	flag.Var(&dotAttrs.node, "node-attr", "default node attribute key=value in DOT format (repeatable)")
+	flag.Var(&dotAttrs.edge, "edge-attr", "default edge attribute key=value in DOT format (repeatable)")
+	var edgeHeaders headerList
+	flag.Var(&edgeHeaders, "edge-header", "header such as X-Project whose value is written as the header:x-project attribute of the edge of each message in DOT and GEXF formats (repeatable)")
+	verbose := flag.Bool("verbose", false, "verbosely log warnings (same as -log-level debug)")
+	logLevel := flag.String("log-level", "info", "minimum level of logged messages (error, warn, info or debug)")
+	showProgress := flag.Bool("progress", false, "report progress to stderr while reading messages")
+	printStats := flag.Bool("stats", false, "print message statistics to stderr on completion")
+	dryRun := flag.Bool("dry-run", false, "print graph size, statistics and the most frequently excluded and dropped addresses to stderr instead of writing output")
+	serve := flag.String("serve", "", "serve graphs of mbox data POSTed to this address, such as :8080, instead of reading input")
+	maxBody := flag.String("max-body", "32M", "maximum request body size for -serve with optional K, M or G suffix")
+	timeout := flag.Duration("timeout", time.Minute, "maximum time to handle a request for -serve")
+	config := flag.String("config", "", "file of flag defaults, one name=value per line, overridden by MBG_* environment variables and command line flags")
 	flag.Parse()
+	err := setDefaults(flag.CommandLine, *config)
+	if err != nil {
+		fatal("failed to set flag defaults", "error", err)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fatal("invalid log level", "level", *logLevel)
+	}
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	*verbose = level <= slog.LevelDebug
+	var prog *progress
+	var logOut io.Writer = os.Stderr
+	if *showProgress {
+		// Log output is written above the progress line.
+		prog = &progress{w: os.Stderr}
+		logOut = prog
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(logOut, &slog.HandlerOptions{Level: level})))
 
+	var include *regexp.Regexp
+	if *incl != "" {
+		include, err = regexp.Compile(*incl)
+		if err != nil {
+			fatal("failed to parse include pattern", "pattern", *incl, "error", err)
+		}
+	}
 	var exclude *regexp.Regexp
-	var err error
 	if *excl != "" {
 		exclude, err = regexp.Compile(*excl)
 		if err != nil {
-			log.Fatalf("failed to parse exclude pattern: %v", *excl)
+			fatal("failed to parse exclude pattern", "pattern", *excl, "error", err)
+		}
+	}
+	var excluded addrSet
+	if *exclFile != "" {
+		excluded, err = readAddrSet(*exclFile, *caseSensitiveLocal)
+		if err != nil {
+			fatal("failed to read exclude file", "path", *exclFile, "error", err)
+		}
+	}
+	var aliases map[string]string
+	if *aliasFile != "" {
+		aliases, err = readAliases(*aliasFile, *caseSensitiveLocal)
+		if err != nil {
+			fatal("failed to read alias file", "path", *aliasFile, "error", err)
+		}
+	}
+	var dropIDs map[string]bool
+	if *dropMIDFile != "" {
+		dropIDs, err = readMessageIDs(*dropMIDFile)
+		if err != nil {
+			fatal("failed to read drop Message-ID file", "path", *dropMIDFile, "error", err)
 		}
 	}
 	var dropFrom *regexp.Regexp
 	if *drop != "" {
 		dropFrom, err = regexp.Compile(*drop)
 		if err != nil {
-			log.Fatalf("failed to parse drop-from pattern: %v", *drop)
+			fatal("failed to parse drop-from pattern", "pattern", *drop, "error", err)
 		}
 	}
 
-	ms := mbox.NewReader(os.Stdin)
+	dialect, err := parseDialect(*dialectName)
+	if err != nil {
+		fatal("failed to parse mbox dialect", "error", err)
+	}
 
-	g := addrGraph{multi.NewUndirectedGraph(), make(map[string]int64)}
+	bufSize, err := parseSize(*buffer)
+	if err != nil {
+		fatal("failed to parse buffer size", "error", err)
+	}
 
-messages:
-	for {
-		r, err := ms.NextMessage()
+	// The inverse metric is a distance that is only
+	// used for output. Edges are pruned and measured
+	// by message count.
+	inverse := *metric == "inverse"
+	if inverse {
+		*metric = "messages"
+	}
+	// The decay weight is relative to the newest
+	// message, which is only known once all the
+	// input has been read.
+	var newest time.Time
+	weight, ok := weightFuncs[*metric]
+	if *metric == "decay" {
+		if *halfLife <= 0 {
+			fatal("-half-life must be positive")
+		}
+		weight, ok = decayed(&newest, *halfLife), true
+	}
+	if !ok {
+		fatal("invalid weight metric", "weight", *metric)
+	}
+	var recipientWeights map[string]float64
+	if *headerWeights != "" {
+		if *metric != "messages" {
+			fatal("-weights requires -weight messages or inverse")
+		}
+		recipientWeights, err = parseHeaderWeights(*headerWeights)
 		if err != nil {
-			if err != io.EOF {
-				log.Fatalf("failed to get message: %v", err)
-			}
+			fatal("failed to parse header weights", "error", err)
+		}
+		weight = headerWeighted
+	}
+
+	formats := strings.Split(*format, ",")
+	// The jsonl format writes lines as events as they
+	// are read, so no graph is built, pruned or measured.
+	stream := *format == "jsonl"
+	if stream {
+		if *slice > 0 || *serve != "" || *dryRun {
+			fatal("-format jsonl cannot be used with -slice, -serve or -dry-run")
+		}
+		if *reciprocal || *minWeight > 0 || *minDegree > 0 || *top > 0 || *components || *largest || *communities || *centrality != "" {
+			slog.Warn("ignoring pruning and measuring options for -format jsonl")
+		}
+	}
+	for _, f := range formats {
+		if stream {
 			break
 		}
+		if f == "jsonl" {
+			fatal("-format jsonl cannot be combined with other formats")
+		}
+		if _, ok := formatExt[f]; !ok {
+			fatal("invalid format", "format", f)
+		}
+		if f == "gephi-csv" && !*dryRun && (*output == "" || *output == "-") {
+			fatal("-format gephi-csv requires an -output base path")
+		}
+	}
+	if len(formats) > 1 && !*dryRun && (*output == "" || *output == "-") {
+		fatal("multiple formats require an -output base path")
+	}
+	if *slice > 0 && !*dryRun && (*output == "" || *output == "-") {
+		fatal("-slice requires an -output file name")
+	}
+	if *serve != "" && (flag.NArg() != 0 || *maildir != "" || *recursive != "" || *load != "" || *slice > 0 || *dryRun || *showProgress) {
+		fatal("-serve cannot be used with input files, -maildir, -recursive, -load, -slice, -dry-run or -progress")
+	}
 
-		m, err := mail.ReadMessage(r)
+	var since, until time.Time
+	now := time.Now()
+	if *start != "" {
+		since, err = parseTime(*start, now)
 		if err != nil {
-			log.Fatalf("failed to get read message: %v", err)
+			fatal("failed to parse since time", "error", err)
 		}
-		addrs, err := extractAddrs(nil, m.Header, "from", exclude, dropFrom)
+	}
+	if *end != "" {
+		until, err = parseTime(*end, now)
 		if err != nil {
-			if err == dropMessage {
-				continue messages
+			fatal("failed to parse until time", "error", err)
+		}
+	} else if _, err := parseDuration(*start); err == nil {
+		until = now
+	}
+
+	if *bipartite && (*byDomain || *thread || *crossDomain || *anonymize) {
+		fatal("-bipartite cannot be used with -by-domain, -thread, -cross-domain-only or -anonymize")
+	}
+	if *crossDomain && *anonymize && !*byDomain {
+		fatal("-cross-domain-only requires -by-domain with -anonymize")
+	}
+	if !layouts[*layout] {
+		fatal("invalid layout", "layout", *layout)
+	}
+	if !centralities[*centrality] {
+		fatal("invalid centrality", "centrality", *centrality)
+	}
+	if !bccModes[*bccMode] {
+		fatal("invalid Bcc mode", "bcc-mode", *bccMode)
+	}
+	if *head < 0 || *tail < 0 {
+		fatal("-head and -tail must not be negative")
+	}
+	if *serve != "" && (*head > 0 || *tail > 0) {
+		fatal("-serve cannot be used with -head or -tail")
+	}
+	if *serve != "" && *metric == "decay" {
+		fatal("-serve cannot be used with -weight decay")
+	}
+	if *sentReceived && !*directed {
+		slog.Warn("ignoring -sent-received for undirected graph")
+		*sentReceived = false
+	}
+	if *truncateRecipients && *maxRecipients <= 0 {
+		fatal("-truncate-recipients requires -max-recipients")
+	}
+	if *focus != "" {
+		if !contains(formats, "summary") {
+			fatal("-focus requires -format summary")
+		}
+		*focus = contact.FoldAddr(*focus, *caseSensitiveLocal)
+		if canon, ok := aliases[*focus]; ok {
+			*focus = canon
+		}
+	}
+
+	var saltBytes []byte
+	if *anonymize {
+		if *salt == "" {
+			r := make([]byte, 8)
+			_, err = rand.Read(r)
+			if err != nil {
+				fatal("failed to generate salt", "error", err)
+			}
+			*salt = hex.EncodeToString(r)
+			slog.Info("anonymizing addresses", "salt", *salt)
+		}
+		saltBytes = []byte(*salt)
+	}
+
+	originators, recipients, unknown := parseHeaders(*headers)
+	if len(unknown) != 0 {
+		slog.Debug("ignoring unknown headers", "headers", strings.Join(unknown, ","))
+	}
+
+	b := newBuilder(options{
+		directed:    *directed || *reciprocal,
+		weight:      weight,
+		originators: originators,
+		recipients:  recipients,
+		include:     include,
+		exclude:     exclude,
+		excluded:    excluded,
+		dropIDs:     dropIDs,
+		dropFrom:    dropFrom,
+		since:       since,
+		until:       until,
+		requireDate: *requireDate,
+		byDomain:    *byDomain,
+		crossDomain: *crossDomain,
+		bipartite:   *bipartite,
+		thread:      *thread,
+		bySubject:   *bySubject,
+		dedup:       *dedup,
+		selfLoops:   *selfLoops,
+		verbose:     *verbose,
+
+		maxRecipients:      *maxRecipients,
+		truncateRecipients: *truncateRecipients,
+		headerWeights:      recipientWeights,
+		bccMode:            *bccMode,
+		ignoreAutomated:    *ignoreAutomated,
+		includeDomains:     parseDomainSet(*inclDomains),
+		excludeDomains:     parseDomainSet(*exclDomains),
+		normalizeGmail:     *normalizeGmail,
+		caseSensitiveLocal: *caseSensitiveLocal,
+		dialect:            dialect,
+		aliases:            aliases,
+		salt:               saltBytes,
+		slice:              *slice,
+		edgeHeaders:        edgeHeaders,
+		skipOversize:       *skipOversize,
+		head:               *head,
+		tail:               *tail,
+		bufSize:            bufSize,
+	})
+
+	if *hubs != "" {
+		b.setHubs(*hubs)
+	}
+	if *dryRun {
+		b.stats.excludedAddrs = &tally{}
+		b.stats.droppedAddrs = &tally{}
+	}
+	var events *os.File
+	if stream {
+		events = os.Stdout
+		if *output != "" && *output != "-" {
+			events, err = os.Create(*output)
+			if err != nil {
+				fatal("failed to create output", "error", err)
+			}
+		}
+		b.events = newEventWriter(events)
+	}
+	b.progress = prog
+
+	// encode writes g to out in the given format.
+	encode := func(out io.Writer, format string, g addrGraph) error {
+		switch format {
+		case "dot", "dot-clustered":
+			b, err := dot.MarshalMulti(g.encodable(dotAttrs, *dotColor, format == "dot-clustered"), *graphName, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to format DOT: %v", err)
+			}
+			// The DOT encoder has no streaming API, so write
+			// its buffer directly rather than copying it again
+			// through fmt.
+			_, err = out.Write(b)
+			if err == nil {
+				_, err = io.WriteString(out, "\n")
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write DOT: %v", err)
+			}
+		case "svg":
+			b, err := dot.MarshalMulti(g.encodable(dotAttrs, *dotColor, false), *graphName, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to format DOT: %v", err)
+			}
+			err = renderSVG(out, b, *layout)
+			if err != nil {
+				return fmt.Errorf("failed to render SVG: %v", err)
+			}
+		case "gexf":
+			err := marshalGexf(out, g, *static, *collapse, false, edgeHeaders)
+			if err != nil {
+				return fmt.Errorf("failed to format GEXF: %v", err)
+			}
+		case "tgexf":
+			err := marshalGexf(out, g, false, true, true, edgeHeaders)
+			if err != nil {
+				return fmt.Errorf("failed to format GEXF: %v", err)
+			}
+		case "graphml":
+			err := marshalGraphML(out, g)
+			if err != nil {
+				return fmt.Errorf("failed to format GraphML: %v", err)
+			}
+		case "gml":
+			err := marshalGML(out, g)
+			if err != nil {
+				return fmt.Errorf("failed to format GML: %v", err)
+			}
+		case "edgelist":
+			err := marshalEdgeList(out, g, *delim)
+			if err != nil {
+				return fmt.Errorf("failed to format edge list: %v", err)
+			}
+		case "json":
+			err := marshalJSON(out, g, *jsonAddr)
+			if err != nil {
+				return fmt.Errorf("failed to format JSON: %v", err)
 			}
-			if *verbose {
-				log.Printf("failed to extract from: address list: %v", err)
+		case "pajek":
+			err := marshalPajek(out, g)
+			if err != nil {
+				return fmt.Errorf("failed to format Pajek: %v", err)
 			}
+		case "mermaid":
+			err := marshalMermaid(out, g)
+			if err != nil {
+				return fmt.Errorf("failed to format Mermaid: %v", err)
+			}
+		case "adjacency":
+			err := marshalAdjacency(out, g)
+			if err != nil {
+				return fmt.Errorf("failed to format adjacency matrix: %v", err)
+			}
+		case "cypher":
+			err := marshalCypher(out, g, *batchSize)
+			if err != nil {
+				return fmt.Errorf("failed to format Cypher: %v", err)
+			}
+		case "graphson":
+			err := marshalGraphSON(out, g)
+			if err != nil {
+				return fmt.Errorf("failed to format GraphSON: %v", err)
+			}
+		case "summary":
+			err := marshalSummary(out, g, *focus, inverse)
+			if err != nil {
+				return fmt.Errorf("failed to format summary: %v", err)
+			}
+		default:
+			return fmt.Errorf("invalid format: %q", format)
+		}
+		return nil
+	}
+	// marshal writes g to the file at path, or to stdout if
+	// path is empty or "-", in the given format.
+	marshal := func(path, format string, g addrGraph) {
+		if format == "gephi-csv" {
+			err := writeGephiCSV(strings.TrimSuffix(path, ".csv"), g)
+			if err != nil {
+				fatal("failed to write Gephi CSV", "error", err)
+			}
+			return
 		}
-		for _, tag := range []string{"to", "cc", "bcc"} {
-			addrs, err = extractAddrs(addrs, m.Header, tag, exclude, nil)
-			if err != nil && *verbose {
-				log.Printf("failed to extract %v: address list: %v", tag, err)
+
+		var err error
+		out := os.Stdout
+		if path != "" && path != "-" {
+			out, err = os.Create(path)
+			if err != nil {
+				fatal("failed to create output", "error", err)
 			}
 		}
-		date, err := m.Header.Date()
-		if err != nil && *verbose {
-			log.Printf("failed to extract date: %v", err)
+		err = encode(out, format, g)
+		if err != nil {
+			fatal("failed to write graph", "format", format, "error", err)
 		}
-		if len(addrs) < 2 {
-			continue
+		if out != os.Stdout {
+			err = out.Close()
+			if err != nil {
+				fatal("failed to close output", "error", err)
+			}
 		}
-		sort.Strings(addrs)
-		for i, a := range addrs[1:] {
-			if addrs[i] == a {
-				addrs[i] = ""
+	}
+	// prune removes the nodes and edges of g that are
+	// excluded by the pruning options other than
+	// -largest-component.
+	prune := func(g addrGraph) addrGraph {
+		if *reciprocal {
+			g.keepReciprocal()
+			if !*directed {
+				g = g.undirected()
 			}
 		}
-		for i := 0; i < len(addrs); {
-			if addrs[i] == "" {
-				addrs[i], addrs = addrs[len(addrs)-1], addrs[:len(addrs)-1]
-			} else {
-				i++
+		if *minWeight > 0 {
+			g.pruneEdges(*minWeight)
+		}
+		if *minDegree > 0 {
+			g.pruneNodes(*minDegree)
+		}
+		if *top > 0 {
+			g.keepTop(*top)
+		}
+		return g
+	}
+	// measure returns g ordered for output with its
+	// nodes measured.
+	measure := func(g addrGraph) addrGraph {
+		g = g.sorted(*hashIDs)
+		g.measureDegrees()
+		g.measureContacts()
+		if *sentReceived {
+			g.measureMessages()
+		}
+		if *centrality == "betweenness" {
+			g.measureBetweenness()
+		}
+		if *communities {
+			g.measureCommunities(*resolution, *seed)
+		}
+		if inverse {
+			g.weight = inverted(g.weight)
+		}
+		return g
+	}
+	// write writes g to the file at path, or to stdout if
+	// path is empty or "-", after pruning and measuring it.
+	// If more than one format is requested, path is a base
+	// path that is given the extension of each format.
+	write := func(path string, g addrGraph) {
+		g = prune(g)
+		if *components {
+			if *slice > 0 {
+				fmt.Fprintf(os.Stderr, "%s: ", path)
 			}
+			comps := g.components()
+			sizes := make([]string, len(comps))
+			for i, c := range comps {
+				sizes[i] = strconv.Itoa(len(c))
+			}
+			fmt.Fprintf(os.Stderr, "%d components: %s\n", len(comps), strings.Join(sizes, " "))
+		}
+		if *largest {
+			g.keepLargestComponent()
 		}
-		if len(addrs) < 2 {
-			if date.IsZero() && *verbose {
-				log.Print("not enough addresses")
-			} else if *verbose {
-				log.Printf("not enough addresses for message at %v", date)
+		if *dryRun {
+			if *slice > 0 {
+				fmt.Fprintf(os.Stderr, "%s: ", path)
 			}
-			continue
+			fmt.Fprintf(os.Stderr, "%d nodes, %d edges\n", g.Nodes().Len(), g.Edges().Len())
+			return
+		}
+
+		g = measure(g)
+
+		for _, f := range formats {
+			p := path
+			if len(formats) > 1 {
+				p += formatExt[f]
+			}
+			marshal(p, f, g)
+		}
+	}
+
+	if *serve != "" {
+		maxBytes, err := parseSize(*maxBody)
+		if err != nil {
+			fatal("failed to parse maximum body size", "error", err)
+		}
+		srv := server{
+			opts:     b.options,
+			maxBytes: int64(maxBytes),
+			build: func(g addrGraph) addrGraph {
+				g = prune(g)
+				if *largest {
+					g.keepLargestComponent()
+				}
+				return measure(g)
+			},
+			encode: encode,
 		}
-		mid := m.Header.Get("message-id")
+		fatal("failed to serve", "error", srv.listenAndServe(*serve, *timeout))
+	}
 
-		for i, p := range addrs {
-			for _, q := range addrs[i+1:] {
-				g.SetLine(g.message(p, q, date, mid))
+	if *load != "" {
+		err = b.loadEvents(*load)
+		if err != nil {
+			fatal("failed to load events", "path", *load, "error", err)
+		}
+	}
+	paths := flag.Args()
+	if *recursive != "" {
+		files, err := mboxFiles(*recursive)
+		if err != nil {
+			fatal("failed to search directory", "path", *recursive, "error", err)
+		}
+		slog.Debug("found mbox files", "path", *recursive, "files", len(files))
+		paths = append(paths, files...)
+	}
+	if len(paths) == 0 && *maildir == "" && *recursive == "" {
+		paths = []string{"-"}
+	}
+	err = b.addFiles(paths, *jobs)
+	if err != nil {
+		fatal("failed to read input", "error", err)
+	}
+	if *maildir != "" {
+		err = b.addMaildir(*maildir)
+		if err != nil {
+			fatal("failed to read maildir", "path", *maildir, "error", err)
+		}
+	}
+	b.addTail()
+	if b.progress != nil {
+		b.reportProgress(true)
+	}
+	if *thread {
+		b.linkThreads()
+	}
+	if *requireDate {
+		slog.Debug("skipped undated messages", "count", b.stats.undated)
+	}
+	newest = b.newest()
+	if stream {
+		err = b.events.flush()
+		if err != nil {
+			fatal("failed to write events", "error", err)
+		}
+		if events != os.Stdout {
+			err = events.Close()
+			if err != nil {
+				fatal("failed to close output", "error", err)
+			}
+		}
+	} else if *slice > 0 {
+		ext := filepath.Ext(*output)
+		prefix := strings.TrimSuffix(*output, ext)
+		starts := make([]time.Time, 0, len(b.slices))
+		for start := range b.slices {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		for _, start := range starts {
+			suffix := "undated"
+			if !start.IsZero() {
+				suffix = start.Format(sliceTime)
 			}
+			write(prefix+"-"+suffix+ext, b.slices[start])
 		}
+	} else {
+		write(*output, b.g)
 	}
 
-	switch *format {
-	case "dot":
-		b, err := dot.MarshalMulti(g, "", "", "  ")
+	if *printStats || *dryRun {
+		err = b.stats.writeTo(os.Stderr)
 		if err != nil {
-			log.Fatalf("failed to format DOT: %v", err)
+			fatal("failed to write statistics", "error", err)
 		}
-		fmt.Printf("%s\n", b)
-	case "gexf":
-		err := marshalGexf(os.Stdout, g)
+	}
+	if *dryRun {
+		err = b.stats.excludedAddrs.writeTo(os.Stderr, "most excluded addresses", 10)
 		if err != nil {
-			log.Fatalf("failed to format GEXF: %v", err)
+			fatal("failed to write statistics", "error", err)
+		}
+		err = b.stats.droppedAddrs.writeTo(os.Stderr, "most dropped senders", 10)
+		if err != nil {
+			fatal("failed to write statistics", "error", err)
 		}
-	default:
-		log.Fatalf("invalid format: %q", *format)
 	}
 }
 
 const dateTime = "2006-01-02T15:04:05"
 
-var dropMessage = errors.New("drop message")
+// fatal logs msg with the given attributes at error level and
+// exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// formatExt holds the file extension given to the output of each
+// format when more than one format is requested. Gephi CSV output
+// names its files from the base path.
+var formatExt = map[string]string{
+	"dot":           ".dot",
+	"dot-clustered": ".clustered.dot",
+	"gexf":          ".gexf",
+	"tgexf":         ".spells.gexf",
+	"graphml":       ".graphml",
+	"gml":           ".gml",
+	"edgelist":      ".txt",
+	"json":          ".json",
+	"pajek":         ".net",
+	"adjacency":     ".csv",
+	"mermaid":       ".mmd",
+	"cypher":        ".cypher",
+	"graphson":      ".graphson",
+	"summary":       ".summary",
+	"svg":           ".svg",
+	"gephi-csv":     "",
+}
+
+// centralities holds the valid values of -centrality, where the
+// empty string measures no centrality.
+var centralities = map[string]bool{
+	"":            true,
+	"betweenness": true,
+}
+
+// bccModes holds the valid values of -bcc-mode. Directed graphs
+// only link senders to recipients, so to-sender-only has the same
+// effect as clique for them.
+var bccModes = map[string]bool{
+	"clique":         true,
+	"to-sender-only": true,
+	"ignore":         true,
+}
+
+// sliceTime is the format of the window start in the names
+// of -slice output files.
+const sliceTime = "20060102T150405Z"
+
+// parseTime parses s as an RFC3339 time, or failing that, as a
+// dateTime in UTC, or as a duration before now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	t, err = time.Parse(dateTime, s)
+	if err == nil {
+		return t, nil
+	}
+	d, derr := parseDuration(s)
+	if derr != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %q", s)
+	}
+	return now.Add(-d), nil
+}
+
+// parseDuration parses s as a Go duration, or as an integer number
+// of days or weeks with a d or w suffix.
+func parseDuration(s string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		return time.ParseDuration(s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 || time.Duration(n) > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid duration: %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// durationFlag is a duration flag value that is parsed by
+// parseDuration.
+type durationFlag time.Duration
+
+func (d *durationFlag) String() string { return time.Duration(*d).String() }
 
-func extractAddrs(dst []string, h mail.Header, tag string, exclude, drop *regexp.Regexp) ([]string, error) {
-	addrs, err := h.AddressList(tag)
+func (d *durationFlag) Set(s string) error {
+	v, err := parseDuration(s)
 	if err != nil {
-		if err == mail.ErrHeaderNotPresent {
-			err = nil
+		return err
+	}
+	*d = durationFlag(v)
+	return nil
+}
+
+// headerList is a list of header names that can be set by
+// repeated flags.
+type headerList []string
+
+func (h *headerList) String() string { return strings.Join(*h, ",") }
+
+func (h *headerList) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, ": \t") {
+		return fmt.Errorf("invalid header name %q", s)
+	}
+	// Header names are case-insensitive, so each
+	// is kept once in its canonical form.
+	s = textproto.CanonicalMIMEHeaderKey(s)
+	for _, name := range *h {
+		if name == s {
+			return nil
 		}
-		return dst, err
 	}
-	for _, a := range addrs {
-		addr := strings.ToLower(a.Address)
-		if drop != nil && drop.MatchString(addr) {
-			return nil, dropMessage
+	*h = append(*h, s)
+	return nil
+}
+
+// headerAttr returns the attribute key for the header name, which
+// is namespaced so that it cannot collide with other attributes.
+func headerAttr(name string) string {
+	return "header:" + strings.ToLower(name)
+}
+
+// parseSize returns the number of bytes in the size s, which is an
+// integer with an optional case-insensitive K, M or G binary suffix.
+func parseSize(s string) (int, error) {
+	n := strings.ToUpper(strings.TrimSpace(s))
+	unit := 1
+	if n != "" {
+		switch n[len(n)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
 		}
-		if exclude != nil && exclude.MatchString(addr) {
-			continue
+		if unit != 1 {
+			n = n[:len(n)-1]
+		}
+	}
+	v, err := strconv.Atoi(n)
+	if err != nil || v <= 0 || v > int(^uint(0)>>1)/unit {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return v * unit, nil
+}
+
+var (
+	dropMessage  = errors.New("drop message")
+	tooManyAddrs = errors.New("too many addresses")
+)
+
+// dedup returns addrs with duplicate addresses removed. The
+// order of addrs is not retained.
+func dedup(addrs []string) []string {
+	if len(addrs) < 2 {
+		return addrs
+	}
+	sort.Strings(addrs)
+	for i, a := range addrs[1:] {
+		if addrs[i] == a {
+			addrs[i] = ""
+		}
+	}
+	for i := 0; i < len(addrs); {
+		if addrs[i] == "" {
+			addrs[i], addrs = addrs[len(addrs)-1], addrs[:len(addrs)-1]
+		} else {
+			i++
+		}
+	}
+	return addrs
+}
+
+// repeated returns the addresses that occur more than once
+// in addrs in sorted order.
+func repeated(addrs []string) []string {
+	var rep []string
+	seen := make(map[string]int)
+	for _, a := range addrs {
+		seen[a]++
+		if seen[a] == 2 {
+			rep = append(rep, a)
 		}
-		dst = append(dst, addr)
 	}
-	return dst, nil
+	sort.Strings(rep)
+	return rep
 }
 
 // addrGraph is a multigraph based on string IDs.
 type addrGraph struct {
-	*multi.UndirectedGraph
+	multigraph
+
+	id     map[string]int64
+	weight weightFunc
+}
+
+// multigraph is the graph behaviour required by addrGraph. It
+// is satisfied by *multi.UndirectedGraph and *multi.DirectedGraph.
+type multigraph interface {
+	graph.Multigraph
+	graph.NodeAdder
+	graph.NodeRemover
+	graph.LineAdder
+	graph.LineRemover
+
+	Edges() graph.Edges
+}
+
+// newAddrGraph returns a new addrGraph, holding a directed
+// multigraph if directed is true. Edge weights are calculated
+// from the lines between nodes using the weight function.
+func newAddrGraph(directed bool, weight weightFunc) addrGraph {
+	if directed {
+		return addrGraph{multi.NewDirectedGraph(), make(map[string]int64), weight}
+	}
+	return addrGraph{multi.NewUndirectedGraph(), make(map[string]int64), weight}
+}
+
+// isDirected returns whether g holds a directed multigraph.
+func (g addrGraph) isDirected() bool {
+	_, ok := g.multigraph.(graph.Directed)
+	return ok
+}
+
+// sorted returns a copy of g with node and line IDs assigned in
+// address order, and lines between the same nodes in date order,
+// so that graphs built from the same messages are encoded
+// identically regardless of the order in which messages were
+// added. If hashed is true, node IDs are derived from a hash of
+// their address by addrID instead, so that the ID of an address
+// does not depend on the other addresses in the graph. Node
+// attributes are shared with g.
+func (g addrGraph) sorted(hashed bool) addrGraph {
+	s := newAddrGraph(g.isDirected(), g.weight)
+	for _, p := range g.sortedPeople() {
+		if hashed {
+			p.Node = multi.Node(addrID(p.addr, func(id int64) bool { return s.Node(id) != nil }))
+		} else {
+			p.Node = s.NewNode()
+		}
+		s.AddNode(p)
+		s.id[p.addr] = p.ID()
+	}
+	for _, e := range g.sortedEdges() {
+		for _, l := range sortedMessages(e.Lines) {
+			m := l.(message)
+			m.Line = s.NewLine(s.Node(s.id[m.From().(person).addr]), s.Node(s.id[m.To().(person).addr]))
+			s.SetLine(m)
+		}
+	}
+	return s
+}
+
+// addrID returns a non-negative ID for addr taken from the
+// SHA-256 hash of the address. If the ID is already used, as
+// reported by used, the following IDs are probed in turn.
+func addrID(addr string, used func(int64) bool) int64 {
+	h := sha256.Sum256([]byte(addr))
+	id := int64(binary.BigEndian.Uint64(h[:8]) & math.MaxInt64)
+	for used(id) {
+		id = (id + 1) & math.MaxInt64
+	}
+	return id
+}
+
+// sortedPeople returns the nodes of g sorted by address.
+func (g addrGraph) sortedPeople() []person {
+	nodes := g.Nodes()
+	people := make([]person, 0, nodes.Len())
+	for nodes.Next() {
+		people = append(people, nodes.Node().(person))
+	}
+	sort.Slice(people, func(i, j int) bool { return people[i].addr < people[j].addr })
+	return people
+}
+
+// sortedEdges returns the edges of g sorted by the addresses of
+// their end points. The end points of undirected edges are compared
+// and returned in address order.
+func (g addrGraph) sortedEdges() []multi.Edge {
+	directed := g.isDirected()
+	type keyed struct {
+		u, v string
+		e    multi.Edge
+	}
+	var edges []keyed
+	it := g.Edges()
+	for it.Next() {
+		e := it.Edge().(multi.Edge)
+		u, v := e.F.(person).addr, e.T.(person).addr
+		if !directed && v < u {
+			u, v = v, u
+			e = multi.Edge{F: e.T, T: e.F, Lines: e.Lines}
+		}
+		edges = append(edges, keyed{u: u, v: v, e: e})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].u != edges[j].u {
+			return edges[i].u < edges[j].u
+		}
+		return edges[i].v < edges[j].v
+	})
+	sorted := make([]multi.Edge, len(edges))
+	for i, e := range edges {
+		sorted[i] = e.e
+	}
+	return sorted
+}
+
+// sortedMessages returns the messages in lines sorted by date, then
+// by message ID, subject and raw addresses.
+func sortedMessages(lines graph.Lines) []graph.Line {
+	messages := graph.LinesOf(lines)
+	lines.Reset()
+	sort.Slice(messages, func(i, j int) bool {
+		mi, mj := messages[i].(message), messages[j].(message)
+		switch {
+		case !mi.date.Equal(mj.date):
+			return mi.date.Before(mj.date)
+		case mi.mid != mj.mid:
+			return mi.mid < mj.mid
+		case mi.subject != mj.subject:
+			return mi.subject < mj.subject
+		}
+		return strings.Join(mi.raw, ", ") < strings.Join(mj.raw, ", ")
+	})
+	return messages
+}
+
+// encodable returns g as a graph that encoders can identify as
+// directed when g holds a directed multigraph, with each line
+// annotated with the number of lines in its edge, a summary of
+// their subjects and a pen width scaled from the edge weight, and
+// with the given DOT graph, node and edge attributes. If color is
+// true, lines are also colored by their pen width. If cluster is
+// true, nodes are grouped into DOT clusters by domain.
+func (g addrGraph) encodable(attrs dotAttributes, color, cluster bool) graph.Multigraph {
+	c := encodableGraph{addrGraph: g, attrs: attrs}
+	if cluster {
+		c.clusterByDomain()
+	}
+	if color {
+		c.maxWidth = 1
+		edges := g.Edges()
+		for edges.Next() {
+			e := edges.Edge().(multi.Edge)
+			c.maxWidth = math.Max(c.maxWidth, scaleWeight(g.weight(e.Lines)))
+		}
+	}
+	if g.isDirected() {
+		return directedAddrGraph{c}
+	}
+	return c
+}
+
+// encodableGraph is an addrGraph that returns lines annotated
+// with attributes of their edge, and that has top-level DOT
+// attributes.
+type encodableGraph struct {
+	addrGraph
+	attrs dotAttributes
+
+	// maxWidth is the largest pen width of the
+	// edges if lines are colored, and zero
+	// otherwise.
+	maxWidth float64
+
+	// clusters holds the DOT clusters of the
+	// graph and clustered holds the IDs of the
+	// nodes in them if nodes are clustered.
+	clusters  []dot.Multigraph
+	clustered map[int64]bool
+}
+
+func (g encodableGraph) Lines(uid, vid int64) graph.Lines {
+	lines := graph.LinesOf(g.addrGraph.Lines(uid, vid))
+	if len(lines) == 0 {
+		return graph.Empty
+	}
+	subjects := subjectSummary(lines)
+	width := scaleWeight(g.weight(g.addrGraph.Lines(uid, vid)))
+	var color string
+	if g.maxWidth != 0 {
+		color = penColor(width, g.maxWidth)
+	}
+	for i, l := range lines {
+		lines[i] = edgeMessage{message: l.(message), count: len(lines), subjects: subjects, width: width, color: color}
+	}
+	return iterator.NewOrderedLines(lines)
+}
+
+func (g encodableGraph) DOTAttributers() (graph, node, edge encoding.Attributer) {
+	return g.attrs.graph, g.attrs.node, g.attrs.edge
+}
+
+// dotAttributes holds the top-level DOT graph, node and edge
+// attributes.
+type dotAttributes struct {
+	graph, node, edge attributes
+}
+
+// attributes is a list of attributes that can be set by
+// repeated key=value flags.
+type attributes []encoding.Attribute
+
+func (a attributes) Attributes() []encoding.Attribute { return a }
+
+func (a *attributes) String() string {
+	s := make([]string, len(*a))
+	for i, attr := range *a {
+		s[i] = attr.Key + "=" + attr.Value
+	}
+	return strings.Join(s, ",")
+}
+
+func (a *attributes) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("invalid attribute %q: must be key=value", s)
+	}
+	*a = append(*a, encoding.Attribute{Key: s[:i], Value: s[i+1:]})
+	return nil
+}
+
+// edgeMessage is a message annotated with the number of lines
+// in its edge, a summary of their subjects, and the pen width
+// and color for drawing it.
+type edgeMessage struct {
+	message
+	count    int
+	subjects string
+	width    float64
+	color    string
+}
+
+func (l edgeMessage) Attributes() []encoding.Attribute {
+	attrs := append(l.message.Attributes(), encoding.Attribute{Key: `"count"`, Value: fmt.Sprint(l.count)})
+	if l.subjects != "" {
+		attrs = append(attrs, encoding.Attribute{Key: `"subjects"`, Value: fmt.Sprintf("%q", l.subjects)})
+	}
+	attrs = append(attrs, encoding.Attribute{Key: "penwidth", Value: fmt.Sprint(l.width)})
+	if l.color != "" {
+		attrs = append(attrs, encoding.Attribute{Key: "color", Value: fmt.Sprintf("%q", l.color)})
+	}
+	return attrs
+}
 
-	id map[string]int64
+// directedAddrGraph is an encodableGraph holding a directed
+// multigraph.
+type directedAddrGraph struct {
+	encodableGraph
+}
+
+var _ graph.Directed = directedAddrGraph{}
+
+func (g directedAddrGraph) HasEdgeFromTo(uid, vid int64) bool {
+	return g.multigraph.(graph.Directed).HasEdgeFromTo(uid, vid)
+}
+
+func (g directedAddrGraph) To(id int64) graph.Nodes {
+	return g.multigraph.(graph.Directed).To(id)
 }
 
 // addrGraph will report edge weights based on line connections
@@ -184,7 +1203,7 @@ func (g addrGraph) person(addr string) graph.Node {
 	if ok {
 		return g.Node(id)
 	}
-	p := person{Node: g.UndirectedGraph.NewNode(), addr: addr}
+	p := person{Node: g.NewNode(), addr: addr, names: make(map[string]int), attrs: &nodeAttrs{}}
 	g.AddNode(p)
 	g.id[addr] = p.ID()
 	return p
@@ -193,8 +1212,9 @@ func (g addrGraph) person(addr string) graph.Node {
 // message returns a graph line representing the message
 // containing addressed individuals represented by the nodes
 // x and y, on the given date and with the given message ID.
-func (g addrGraph) message(x, y string, date time.Time, mid string) graph.Line {
-	return message{Line: g.NewLine(g.person(x), g.person(y)), date: date, mid: mid}
+// In a directed graph the line is from x to y.
+func (g addrGraph) message(x, y graph.Node, date time.Time, mid string) message {
+	return message{Line: g.NewLine(x, y), date: date, mid: mid}
 }
 
 func (g addrGraph) Edge(xid, yid int64) graph.Edge {
@@ -202,48 +1222,190 @@ func (g addrGraph) Edge(xid, yid int64) graph.Edge {
 }
 
 func (g addrGraph) WeightedEdge(xid, yid int64) graph.WeightedEdge {
-	e := g.LinesBetween(xid, yid)
-	if e == nil {
+	e := g.Lines(xid, yid)
+	if e == graph.Empty {
 		return nil
 	}
-	return edge{multi.Edge{F: g.Node(xid), T: g.Node(yid), Lines: e}}
+	return edge{multi.Edge{F: g.Node(xid), T: g.Node(yid), Lines: e}, g.weight}
 }
 
 func (g addrGraph) Weight(xid, yid int64) (float64, bool) {
-	e := g.LinesBetween(xid, yid)
-	if e == nil {
+	e := g.Lines(xid, yid)
+	if e == graph.Empty {
 		return 0, false
 	}
-	return float64(e.Len()), true
+	return g.weight(e), true
+}
+
+// setKind sets the kind of the node for addr if it exists.
+func (g addrGraph) setKind(addr, kind string) {
+	id, ok := g.id[addr]
+	if !ok {
+		return
+	}
+	g.Node(id).(person).attrs.kind = kind
+}
+
+// named records that the address addr was seen with the
+// given display name. Empty names and addresses not in the
+// graph are ignored.
+func (g addrGraph) named(addr, name string) {
+	if name == "" {
+		return
+	}
+	id, ok := g.id[addr]
+	if !ok {
+		return
+	}
+	g.Node(id).(person).names[name]++
 }
 
 type person struct {
 	graph.Node
 	addr string
+
+	// names holds the number of times each
+	// display name was seen with addr.
+	names map[string]int
+
+	// attrs holds attributes measured after
+	// the graph is built.
+	attrs *nodeAttrs
+}
+
+// name returns the display name most frequently seen for the
+// person, or the address if no name has been seen. Ties are
+// broken by lexical order.
+func (n person) name() string {
+	var name string
+	var max int
+	for s, c := range n.names {
+		if c > max || (c == max && s < name) {
+			name = s
+			max = c
+		}
+	}
+	if name == "" {
+		return n.addr
+	}
+	return name
 }
 
 func (n person) DOTID() string { return fmt.Sprintf("%q", n.addr) }
 
+func (n person) Attributes() []encoding.Attribute {
+	attrs := []encoding.Attribute{
+		{Key: "name", Value: fmt.Sprintf("%q", n.name())},
+		{Key: "degree", Value: fmt.Sprint(n.attrs.degree)},
+		{Key: "wdegree", Value: fmt.Sprint(n.attrs.wdegree)},
+	}
+	if !n.attrs.first.IsZero() {
+		attrs = append(attrs,
+			encoding.Attribute{Key: "fd", Value: fmt.Sprint(n.attrs.first)},
+			encoding.Attribute{Key: "first", Value: fmt.Sprint(n.attrs.first.Unix())},
+			encoding.Attribute{Key: "ld", Value: fmt.Sprint(n.attrs.last)},
+			encoding.Attribute{Key: "last", Value: fmt.Sprint(n.attrs.last.Unix())},
+		)
+	}
+	if n.attrs.hasMessages {
+		attrs = append(attrs,
+			encoding.Attribute{Key: "sent", Value: fmt.Sprint(n.attrs.sent)},
+			encoding.Attribute{Key: "received", Value: fmt.Sprint(n.attrs.received)},
+		)
+	}
+	if n.attrs.hasBetweenness {
+		attrs = append(attrs, encoding.Attribute{Key: "betweenness", Value: fmt.Sprint(n.attrs.betweenness)})
+	}
+	if n.attrs.hasCommunity {
+		attrs = append(attrs, encoding.Attribute{Key: "community", Value: fmt.Sprint(n.attrs.community)})
+	}
+	if n.attrs.kind != "" {
+		attrs = append(attrs, encoding.Attribute{Key: "kind", Value: n.attrs.kind})
+	}
+	return attrs
+}
+
 type message struct {
 	graph.Line
-	date time.Time
-	mid  string
+	date    time.Time
+	mid     string
+	subject string
+
+	// headers holds the values of the headers
+	// of the message named by -edge-header.
+	headers []headerValue
+
+	// raw holds the raw forms of canonicalized
+	// addresses of the end points.
+	raw []string
+
+	// participants is the number of distinct
+	// addresses on the message.
+	participants int
+
+	// factor is the contribution of the message
+	// to the weight of the edge when recipient
+	// headers are weighted.
+	factor float64
+
+	// dateSource is the source of the date
+	// if it is recorded.
+	dateSource string
+}
+
+// ReversedLine returns a message with the line's end
+// points reversed.
+func (l message) ReversedLine() graph.Line {
+	l.Line = l.Line.ReversedLine()
+	return l
 }
 
 func (l message) Attributes() []encoding.Attribute {
-	return []encoding.Attribute{
+	attrs := []encoding.Attribute{
 		{Key: `"date"`, Value: fmt.Sprintf("%q", l.date.Format(time.RFC3339))},
 		{Key: `"message-id"`, Value: l.mid}}
+	if len(l.raw) != 0 {
+		attrs = append(attrs, encoding.Attribute{Key: `"raw"`, Value: fmt.Sprintf("%q", strings.Join(l.raw, ", "))})
+	}
+	if l.dateSource != "" {
+		attrs = append(attrs, encoding.Attribute{Key: `"date-source"`, Value: fmt.Sprintf("%q", l.dateSource)})
+	}
+	for _, h := range l.headers {
+		attrs = append(attrs, encoding.Attribute{Key: fmt.Sprintf("%q", headerAttr(h.name)), Value: fmt.Sprintf("%q", h.value)})
+	}
+	return attrs
 }
 
 type edge struct {
 	multi.Edge
+
+	weight weightFunc
 }
 
-func (e edge) Weight() float64 { return float64(e.Edge.Len()) }
+func (e edge) Weight() float64 { return e.weight(e.Lines) }
 
 func (e edge) Attributes() []encoding.Attribute {
-	var sd, ed time.Time
+	sd, ed := e.span()
+	attrs := []encoding.Attribute{
+		{Key: "weight", Value: fmt.Sprint(e.Weight())},
+		{Key: "count", Value: fmt.Sprint(e.Len())},
+		{Key: "sd", Value: fmt.Sprint(sd)},
+		{Key: "start", Value: fmt.Sprint(sd.Unix())},
+		{Key: "ed", Value: fmt.Sprint(ed)},
+		{Key: "end", Value: fmt.Sprint(ed.Unix())},
+		{Key: "penwidth", Value: fmt.Sprint(scaleWeight(e.Weight()))},
+	}
+	subjects := subjectSummary(graph.LinesOf(e.Lines))
+	e.Reset()
+	if subjects != "" {
+		attrs = append(attrs, encoding.Attribute{Key: "subjects", Value: subjects})
+	}
+	return attrs
+}
+
+// span returns the earliest and latest dates of the messages
+// represented by the edge, ignoring messages without a date.
+func (e edge) span() (sd, ed time.Time) {
 	for e.Next() {
 		d := e.Line().(message).date
 		if d.IsZero() {
@@ -257,54 +1419,397 @@ func (e edge) Attributes() []encoding.Attribute {
 		}
 	}
 	e.Reset()
-	return []encoding.Attribute{
-		{Key: "weight", Value: fmt.Sprint(e.Weight())},
-		{Key: "sd", Value: fmt.Sprint(sd)},
-		{Key: "start", Value: fmt.Sprint(sd.Unix())},
-		{Key: "ed", Value: fmt.Sprint(ed)},
-		{Key: "end", Value: fmt.Sprint(ed.Unix())},
+	return sd, ed
+}
+
+// maxSubjects is the maximum number of subjects listed by
+// subjectSummary.
+const maxSubjects = 5
+
+// subjectSummary returns up to maxSubjects distinct subjects of
+// the messages in lines in date order, joined by "; ". If there
+// are more subjects, the number not listed is noted.
+func subjectSummary(lines []graph.Line) string {
+	msgs := make([]message, 0, len(lines))
+	for _, l := range lines {
+		if m := l.(message); m.subject != "" {
+			msgs = append(msgs, m)
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].date.Before(msgs[j].date)
+	})
+	var subjects []string
+	seen := make(map[string]bool)
+	for _, m := range msgs {
+		if !seen[m.subject] {
+			seen[m.subject] = true
+			subjects = append(subjects, m.subject)
+		}
+	}
+	if len(subjects) <= maxSubjects {
+		return strings.Join(subjects, "; ")
+	}
+	return fmt.Sprintf("%s (and %d more)", strings.Join(subjects[:maxSubjects], "; "), len(subjects)-maxSubjects)
+}
+
+// weightFunc returns an edge weight calculated from the lines
+// of the edge. A weightFunc must reset lines before returning.
+type weightFunc func(lines graph.Lines) float64
+
+// weightFuncs are the edge weight metrics selectable with the
+// -weight flag.
+var weightFuncs = map[string]weightFunc{
+	"messages":          messageCount,
+	"days":              dayCount,
+	"unique-recipients": recipientShare,
+}
+
+// inverted returns a weight function returning the reciprocal of
+// the weight given by w, so that strong contacts are near.
+func inverted(w weightFunc) weightFunc {
+	return func(lines graph.Lines) float64 {
+		return 1 / w(lines)
+	}
+}
+
+// headerWeighted returns the sum of the recipient header weights
+// of the messages in lines.
+func headerWeighted(lines graph.Lines) float64 {
+	var w float64
+	for lines.Next() {
+		w += lines.Line().(message).factor
+	}
+	lines.Reset()
+	return w
+}
+
+// messageCount returns the number of messages in lines.
+func messageCount(lines graph.Lines) float64 {
+	return float64(lines.Len())
+}
+
+// recipientShare returns the sum over the messages in lines of
+// 1/(k-1) where k is the number of distinct addresses on the
+// message, so that a message to many recipients contributes
+// little to each pair of its addresses. Messages with fewer
+// than two addresses contribute one.
+func recipientShare(lines graph.Lines) float64 {
+	var w float64
+	for lines.Next() {
+		k := lines.Line().(message).participants
+		if k < 2 {
+			w++
+			continue
+		}
+		w += 1 / float64(k-1)
+	}
+	lines.Reset()
+	return w
+}
+
+// decayed returns a weight function returning the sum over the
+// messages in lines of 0.5^(age/halfLife), where age is the time
+// between the message and *newest, so that recent messages count
+// more. Messages without a date do not contribute.
+func decayed(newest *time.Time, halfLife time.Duration) weightFunc {
+	return func(lines graph.Lines) float64 {
+		var w float64
+		for lines.Next() {
+			d := lines.Line().(message).date
+			if d.IsZero() {
+				continue
+			}
+			w += math.Pow(0.5, float64(newest.Sub(d))/float64(halfLife))
+		}
+		lines.Reset()
+		return w
+	}
+}
+
+// dayCount returns the number of distinct UTC calendar days
+// on which the messages in lines were sent. Messages without
+// a date are not counted.
+func dayCount(lines graph.Lines) float64 {
+	type day struct {
+		year  int
+		month time.Month
+		day   int
 	}
+	days := make(map[day]bool)
+	for lines.Next() {
+		d := lines.Line().(message).date
+		if d.IsZero() {
+			continue
+		}
+		y, m, dd := d.UTC().Date()
+		days[day{y, m, dd}] = true
+	}
+	lines.Reset()
+	return float64(len(days))
 }
 
-func marshalGexf(dst io.Writer, g addrGraph) error {
-	c := gexf12.Content{
-		Graph: gexf12.Graph{
-			TimeFormat:      "dateTime",
-			DefaultEdgeType: "undirected",
-			Mode:            "dynamic",
-			Attributes: []gexf12.Attributes{{
-				Class: "edge",
-				Mode:  "dynamic",
-				Attributes: []gexf12.Attribute{{
-					ID:    "mid",
-					Title: "message-ID",
-					Type:  "string",
-				}},
+// marshalGexf writes g to dst in GEXF format. Unless static is true,
+// the graph is dynamic with an edge for each message at its date,
+// or if collapse is true, with a single edge between each pair of
+// nodes spanning the dates of their messages. If static is true,
+// the graph is static with a single edge between each pair of nodes.
+// Single edges are weighted by the weight of the edge. If spells is
+// true, collapsed edges hold a spell for each distinct message date
+// instead of spanning the dates, so that intermittent contact is
+// shown on the timeline. The values of the named headers of each
+// message are written as attributes of its edge unless edges are
+// static or collapsed.
+//
+// Nodes and edges are encoded as they are visited rather than held
+// in a gexf12.Content so that large graphs do not need to be held
+// twice in memory.
+func marshalGexf(dst io.Writer, g addrGraph, static, collapse, spells bool, headers []string) error {
+	timeFormat, mode := "dateTime", "dynamic"
+	attributes := []gexf12.Attributes{{
+		Class: "node",
+		Mode:  "static",
+		Attributes: []gexf12.Attribute{{
+			ID:    "degree",
+			Title: "degree",
+			Type:  "integer",
+		}, {
+			ID:    "wdegree",
+			Title: "weighted degree",
+			Type:  "double",
+		}, {
+			ID:    "first",
+			Title: "first contact",
+			Type:  "string",
+		}, {
+			ID:    "last",
+			Title: "last contact",
+			Type:  "string",
+		}},
+	}, {
+		Class: "edge",
+		Mode:  "dynamic",
+		Attributes: []gexf12.Attribute{{
+			ID:    "mid",
+			Title: "message-ID",
+			Type:  "string",
+		}, {
+			ID:    "raw",
+			Title: "raw addresses",
+			Type:  "string",
+		}, {
+			ID:    "count",
+			Title: "message count",
+			Type:  "integer",
+		}, {
+			ID:    "subjects",
+			Title: "subjects",
+			Type:  "string",
+		}},
+	}}
+	for _, h := range headers {
+		attributes[1].Attributes = append(attributes[1].Attributes, gexf12.Attribute{
+			ID:    headerAttr(h),
+			Title: h,
+			Type:  "string",
+		})
+	}
+
+	if static {
+		timeFormat, mode = "", "static"
+		attributes[1] = gexf12.Attributes{
+			Class: "edge",
+			Mode:  "static",
+			Attributes: []gexf12.Attribute{{
+				ID:    "count",
+				Title: "message count",
+				Type:  "integer",
+			}, {
+				ID:    "subjects",
+				Title: "subjects",
+				Type:  "string",
 			}},
-		},
-		Version: "1.2",
+		}
 	}
 
-	nodes := g.Nodes()
-	c.Graph.Nodes.Count = nodes.Len()
-	c.Graph.Nodes.Nodes = make([]gexf12.Node, 0, nodes.Len())
-	for nodes.Next() {
-		n := nodes.Node()
-		c.Graph.Nodes.Nodes = append(c.Graph.Nodes.Nodes, gexf12.Node{
-			ID:    fmt.Sprint(n.ID()),
-			Label: n.(person).addr,
+	people := g.sortedPeople()
+	var messages, betweenness, communities, kinds bool
+	for _, n := range people {
+		messages = messages || n.attrs.hasMessages
+		betweenness = betweenness || n.attrs.hasBetweenness
+		communities = communities || n.attrs.hasCommunity
+		kinds = kinds || n.attrs.kind != ""
+	}
+	if messages {
+		attributes[0].Attributes = append(attributes[0].Attributes, gexf12.Attribute{
+			ID:    "sent",
+			Title: "messages sent",
+			Type:  "integer",
+		}, gexf12.Attribute{
+			ID:    "received",
+			Title: "messages received",
+			Type:  "integer",
+		})
+	}
+	if betweenness {
+		attributes[0].Attributes = append(attributes[0].Attributes, gexf12.Attribute{
+			ID:    "betweenness",
+			Title: "betweenness centrality",
+			Type:  "double",
+		})
+	}
+	if communities {
+		attributes[0].Attributes = append(attributes[0].Attributes, gexf12.Attribute{
+			ID:    "community",
+			Title: "community",
+			Type:  "integer",
 		})
 	}
+	if kinds {
+		attributes[0].Attributes = append(attributes[0].Attributes, gexf12.Attribute{
+			ID:    "kind",
+			Title: "kind",
+			Type:  "string",
+		})
+	}
+
+	edges := g.sortedEdges()
+	nEdges := len(edges)
+	if !static && !collapse {
+		nEdges = 0
+		for _, e := range edges {
+			nEdges += e.Len()
+		}
+	}
+
+	_, err := io.WriteString(dst, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(dst)
+	enc.Indent("", "\t")
+
+	root := xml.StartElement{
+		Name: xml.Name{Space: "http://www.gexf.net/1.2draft", Local: "gexf"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "version"}, Value: "1.2"}},
+	}
+	graphElem := xml.StartElement{Name: xml.Name{Local: "graph"}}
+	for _, a := range []xml.Attr{
+		{Name: xml.Name{Local: "timeformat"}, Value: timeFormat},
+		{Name: xml.Name{Local: "defaultedgetype"}, Value: edgeType(g)},
+		{Name: xml.Name{Local: "mode"}, Value: mode},
+	} {
+		if a.Value != "" {
+			graphElem.Attr = append(graphElem.Attr, a)
+		}
+	}
+	err = encodeTokens(enc, root, graphElem)
+	if err != nil {
+		return err
+	}
+	for _, a := range attributes {
+		err = enc.EncodeElement(a, xml.StartElement{Name: xml.Name{Local: "attributes"}})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = enc.EncodeToken(countedElement("nodes", len(people)))
+	if err != nil {
+		return err
+	}
+	size := vizSizer(people)
+	nodeElem := xml.StartElement{Name: xml.Name{Local: "node"}}
+	for _, n := range people {
+		atts := []gexf12.AttValue{
+			{For: "degree", Value: fmt.Sprint(n.attrs.degree)},
+			{For: "wdegree", Value: fmt.Sprint(n.attrs.wdegree)},
+		}
+		if !n.attrs.first.IsZero() {
+			atts = append(atts,
+				gexf12.AttValue{For: "first", Value: n.attrs.first.Format(dateTime)},
+				gexf12.AttValue{For: "last", Value: n.attrs.last.Format(dateTime)},
+			)
+		}
+		if n.attrs.hasMessages {
+			atts = append(atts,
+				gexf12.AttValue{For: "sent", Value: fmt.Sprint(n.attrs.sent)},
+				gexf12.AttValue{For: "received", Value: fmt.Sprint(n.attrs.received)},
+			)
+		}
+		if n.attrs.hasBetweenness {
+			atts = append(atts, gexf12.AttValue{For: "betweenness", Value: fmt.Sprint(n.attrs.betweenness)})
+		}
+		if n.attrs.hasCommunity {
+			atts = append(atts, gexf12.AttValue{For: "community", Value: fmt.Sprint(n.attrs.community)})
+		}
+		if n.attrs.kind != "" {
+			atts = append(atts, gexf12.AttValue{For: "kind", Value: n.attrs.kind})
+		}
+		err = enc.EncodeElement(gexf12.Node{
+			ID:        fmt.Sprint(n.ID()),
+			Label:     n.name(),
+			AttValues: &gexf12.AttValues{AttValues: atts},
+			Size:      &gexf12.Size{Value: size(n.attrs.wdegree)},
+		}, nodeElem)
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "nodes"}})
+	if err != nil {
+		return err
+	}
 
-	edges := g.Edges()
-	for edges.Next() {
-		e := edges.Edge().(multi.Edge)
-		for e.Next() {
-			m := e.Line().(message)
+	err = enc.EncodeToken(countedElement("edges", nEdges))
+	if err != nil {
+		return err
+	}
+	edgeElem := xml.StartElement{Name: xml.Name{Local: "edge"}}
+	var id int
+	for _, e := range edges {
+		// Share the edge weight between the lines of the edge
+		// so that the sum of parallel edges is the edge weight.
+		share := g.weight(e.Lines) / float64(e.Len())
+		lines := sortedMessages(e.Lines)
+		subjects := subjectSummary(lines)
+		if static || collapse {
+			atts := []gexf12.AttValue{{For: "count", Value: fmt.Sprint(len(lines))}}
+			if subjects != "" {
+				atts = append(atts, gexf12.AttValue{For: "subjects", Value: subjects})
+			}
+			l := gexf12.Edge{
+				ID:        fmt.Sprint(id),
+				Source:    fmt.Sprint(e.F.ID()),
+				Target:    fmt.Sprint(e.T.ID()),
+				Weight:    g.weight(e.Lines),
+				AttValues: &gexf12.AttValues{AttValues: atts},
+			}
+			switch {
+			case static:
+			case spells:
+				l.Spells = messageSpells(lines)
+			default:
+				sd, ed := edge{e, g.weight}.span()
+				if !sd.IsZero() {
+					l.Start = sd.Format(dateTime)
+					l.End = ed.Format(dateTime)
+				}
+			}
+			err = enc.EncodeElement(l, edgeElem)
+			if err != nil {
+				return err
+			}
+			id++
+			continue
+		}
+		for _, l := range lines {
+			m := l.(message)
 			l := gexf12.Edge{
-				ID:     fmt.Sprint(m.ID()),
+				ID:     fmt.Sprint(id),
 				Source: fmt.Sprint(m.From().ID()),
 				Target: fmt.Sprint(m.To().ID()),
+				Weight: share,
 			}
 			var date string
 			if !m.date.IsZero() {
@@ -312,24 +1817,140 @@ func marshalGexf(dst io.Writer, g addrGraph) error {
 				l.Start = date
 				l.End = date
 			}
+			var atts []gexf12.AttValue
 			if m.mid != "" {
-				att := gexf12.AttValue{
-					For:   "mid",
-					Value: m.mid,
-				}
-				if !m.date.IsZero() {
-					att.Start = date
-					att.End = date
+				atts = append(atts, gexf12.AttValue{For: "mid", Value: m.mid})
+			}
+			if len(m.raw) != 0 {
+				atts = append(atts, gexf12.AttValue{For: "raw", Value: strings.Join(m.raw, ", ")})
+			}
+			for _, h := range m.headers {
+				atts = append(atts, gexf12.AttValue{For: headerAttr(h.name), Value: h.value})
+			}
+			if !m.date.IsZero() {
+				for i := range atts {
+					atts[i].Start = date
+					atts[i].End = date
 				}
-				l.AttValues = &gexf12.AttValues{AttValues: []gexf12.AttValue{att}}
 			}
-			c.Graph.Edges.Edges = append(c.Graph.Edges.Edges, l)
+			atts = append(atts, gexf12.AttValue{For: "count", Value: fmt.Sprint(len(lines))})
+			if subjects != "" {
+				atts = append(atts, gexf12.AttValue{For: "subjects", Value: subjects})
+			}
+			l.AttValues = &gexf12.AttValues{AttValues: atts}
+			err = enc.EncodeElement(l, edgeElem)
+			if err != nil {
+				return err
+			}
+			id++
 		}
 	}
-	c.Graph.Edges.Count = len(c.Graph.Edges.Edges)
 
-	fmt.Println(xml.Header)
-	enc := xml.NewEncoder(dst)
-	enc.Indent("", "\t")
-	return enc.Encode(c)
+	err = encodeTokens(enc,
+		xml.EndElement{Name: xml.Name{Local: "edges"}},
+		graphElem.End(),
+		root.End(),
+	)
+	if err != nil {
+		return err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(dst, "\n")
+	return err
+}
+
+// messageSpells returns a spell for each distinct date of the
+// messages in lines, which are in date order, or nil if none of
+// the messages has a date.
+func messageSpells(lines []graph.Line) *gexf12.Spells {
+	var spells []gexf12.Spell
+	var last time.Time
+	for _, l := range lines {
+		m := l.(message)
+		if m.date.IsZero() || m.date.Equal(last) {
+			continue
+		}
+		last = m.date
+		date := m.date.Format(dateTime)
+		spells = append(spells, gexf12.Spell{Start: date, End: date})
+	}
+	if spells == nil {
+		return nil
+	}
+	return &gexf12.Spells{Spells: spells}
+}
+
+// countedElement returns a start element with the given name and a
+// count attribute, which is omitted if count is zero.
+func countedElement(name string, count int) xml.StartElement {
+	e := xml.StartElement{Name: xml.Name{Local: name}}
+	if count != 0 {
+		e.Attr = []xml.Attr{{Name: xml.Name{Local: "count"}, Value: fmt.Sprint(count)}}
+	}
+	return e
+}
+
+// encodeTokens writes the tokens to enc.
+func encodeTokens(enc *xml.Encoder, tokens ...xml.Token) error {
+	for _, t := range tokens {
+		err := enc.EncodeToken(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scaleWeight returns the DOT pen width of an edge of the given
+// weight. Widths grow logarithmically from one so that heavy
+// contacts do not swamp the drawing.
+func scaleWeight(weight float64) float64 {
+	return 1 + math.Log(math.Max(weight, 1))
+}
+
+// minVizSize and maxVizSize are the range of GEXF node sizes.
+const (
+	minVizSize = 1
+	maxVizSize = 50
+)
+
+// vizSizer returns a function that scales a weighted degree of one
+// of the people linearly into the range of GEXF node sizes. The
+// weighted degrees of people must already have been measured.
+func vizSizer(people []person) func(wdegree float64) float64 {
+	if len(people) == 0 {
+		return nil
+	}
+	min, max := people[0].attrs.wdegree, people[0].attrs.wdegree
+	for _, p := range people[1:] {
+		min = math.Min(min, p.attrs.wdegree)
+		max = math.Max(max, p.attrs.wdegree)
+	}
+	if min == max {
+		return func(float64) float64 { return minVizSize }
+	}
+	return func(wdegree float64) float64 {
+		return minVizSize + (maxVizSize-minVizSize)*(wdegree-min)/(max-min)
+	}
+}
+
+// penColor returns the color of an edge of the given pen width along
+// a gradient from light to dark blue, with max the largest width.
+func penColor(width, max float64) string {
+	t := 1.0
+	if max > 1 {
+		t = (width - 1) / (max - 1)
+	}
+	mix := func(a, b byte) byte { return byte(float64(a) + t*(float64(b)-float64(a)) + 0.5) }
+	return fmt.Sprintf("#%02x%02x%02x", mix(0xc6, 0x08), mix(0xdb, 0x30), mix(0xef, 0x6b))
+}
+
+func edgeType(g addrGraph) string {
+	if g.isDirected() {
+		return "directed"
+	}
+	return "undirected"
 }
@@ -0,0 +1,111 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const writeErrorMbox = `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+To: bob@example.com, carol@example.com
+Date: Mon, 2 Jan 2006 15:04:05 -0700
+Message-Id: <1@example.com>
+
+Hello.
+`
+
+// TestGexfWriteError checks that a failure to write GEXF output is
+// reported and ends the process with a non-zero exit status. The
+// test runs main in a subprocess writing to /dev/full, which fails
+// every write.
+func TestGexfWriteError(t *testing.T) {
+	if path := os.Getenv("TEST_MBG_INPUT"); path != "" {
+		os.Args = []string{"mbg", "-format", "gexf", "-output", "/dev/full", path}
+		main()
+		return
+	}
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skipf("no /dev/full: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.mbox")
+	err := os.WriteFile(path, []byte(writeErrorMbox), 0644)
+	if err != nil {
+		t.Fatalf("failed to write mbox: %v", err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGexfWriteError$")
+	cmd.Env = append(os.Environ(), "TEST_MBG_INPUT="+path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		t.Fatalf("expected non-zero exit status: got error:%v", err)
+	}
+	if exit.ExitCode() != 1 {
+		t.Errorf("unexpected exit status: got:%d want:1\n%s", exit.ExitCode(), &stderr)
+	}
+	if !strings.Contains(stderr.String(), "GEXF") {
+		t.Errorf("GEXF write error not reported: %q", &stderr)
+	}
+}
+
+// errWrite is the error returned by failWriter.
+var errWrite = errors.New("write failed")
+
+// failWriter is an io.Writer that always fails.
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errWrite }
+
+var marshalers = []struct {
+	name    string
+	marshal func(io.Writer, addrGraph) error
+}{
+	{name: "gexf", marshal: func(w io.Writer, g addrGraph) error { return marshalGexf(w, g, false, false, false, nil) }},
+	{name: "tgexf", marshal: func(w io.Writer, g addrGraph) error { return marshalGexf(w, g, false, true, true, nil) }},
+	{name: "graphml", marshal: marshalGraphML},
+	{name: "gml", marshal: marshalGML},
+	{name: "pajek", marshal: marshalPajek},
+	{name: "json", marshal: func(w io.Writer, g addrGraph) error { return marshalJSON(w, g, false) }},
+	{name: "edgelist", marshal: func(w io.Writer, g addrGraph) error { return marshalEdgeList(w, g, "\t") }},
+	{name: "adjacency", marshal: marshalAdjacency},
+	{name: "cypher", marshal: func(w io.Writer, g addrGraph) error { return marshalCypher(w, g, 100) }},
+	{name: "graphson", marshal: marshalGraphSON},
+	{name: "mermaid", marshal: marshalMermaid},
+	{name: "gephi-nodes", marshal: marshalGephiNodes},
+	{name: "gephi-edges", marshal: marshalGephiEdges},
+	{name: "summary", marshal: func(w io.Writer, g addrGraph) error { return marshalSummary(w, g, "", false) }},
+}
+
+func TestMarshalWriteError(t *testing.T) {
+	originators, recipients, _ := parseHeaders("from,to,cc,bcc")
+	g, _, err := buildGraph(strings.NewReader(testMbox), options{
+		weight:      messageCount,
+		originators: originators,
+		recipients:  recipients,
+		bufSize:     1 << 16,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.measureDegrees()
+	for _, m := range marshalers {
+		t.Run(m.name, func(t *testing.T) {
+			err := m.marshal(failWriter{}, g)
+			if !errors.Is(err, errWrite) {
+				t.Errorf("unexpected error: got:%v want:%v", err, errWrite)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoFromLine is returned by an mboxScanner when data precedes
+// the first From_ line.
+var errNoFromLine = errors.New("invalid mbox: missing From_ line")
+
+// fromLine matches an mbox From_ line. A sender, a time and a
+// year are required so that unescaped body lines starting with
+// "From " are not mistaken for message separators.
+var fromLine = regexp.MustCompile(`^From \S+\s.*\d{1,2}:\d\d.*\d{4}`)
+
+// mboxDialect is the way an mbox escapes message lines that
+// start with "From ".
+type mboxDialect int
+
+const (
+	// mboxrd escapes lines matching >*From by adding
+	// a leading >.
+	mboxrd mboxDialect = iota
+
+	// mboxo escapes lines starting with "From " by
+	// adding a leading >, so escaped lines cannot be
+	// distinguished from lines starting with ">From ".
+	mboxo
+
+	// mboxcl escapes lines as for mboxo and gives the
+	// length of each message body in a Content-Length
+	// header.
+	mboxcl
+
+	// mboxcl2 gives the length of each message body
+	// in a Content-Length header and does not escape
+	// lines.
+	mboxcl2
+)
+
+// parseDialect returns the mbox dialect named by s.
+func parseDialect(s string) (mboxDialect, error) {
+	switch s {
+	case "mboxrd":
+		return mboxrd, nil
+	case "mboxo":
+		return mboxo, nil
+	case "mboxcl":
+		return mboxcl, nil
+	case "mboxcl2":
+		return mboxcl2, nil
+	default:
+		return 0, fmt.Errorf("invalid mbox dialect: %q", s)
+	}
+}
+
+// mboxScanner splits mbox data into messages.
+type mboxScanner struct {
+	r *bufio.Reader
+
+	// dialect is the escaping used by the mbox.
+	dialect mboxDialect
+
+	// buf holds the message being read and
+	// limits the size of messages to its
+	// capacity.
+	buf []byte
+
+	// started is whether a From_ line has been
+	// read for the message being read.
+	started bool
+
+	// from is the From_ line of the message
+	// being read and next is the From_ line
+	// of the message that follows it.
+	from, next []byte
+
+	// header is whether the header of the
+	// message is being read, and body is the
+	// number of bytes of the body that remain
+	// to be read according to its
+	// Content-Length header.
+	header bool
+	body   int
+
+	// cont is whether the next read continues
+	// a line longer than the reader's buffer,
+	// and skip is whether that line is a From_
+	// line to be discarded.
+	cont, skip bool
+
+	// skipLong is whether messages larger than
+	// cap(buf) are skipped rather than ending the
+	// scan with an error, and long is the number
+	// of messages skipped. discard is whether the
+	// rest of the message being read is skipped.
+	skipLong bool
+	long     int
+	discard  bool
+
+	err error
+}
+
+// newMboxScanner returns an mboxScanner reading mbox data in the
+// given dialect from r that holds messages in buf. Messages larger
+// than cap(buf) result in a bufio.ErrTooLong error unless skipLong
+// is set, in which case they are skipped and counted in long.
+func newMboxScanner(r io.Reader, buf []byte, dialect mboxDialect) *mboxScanner {
+	return &mboxScanner{r: bufio.NewReader(r), dialect: dialect, buf: buf[:0]}
+}
+
+// Next advances the scanner to the next message, which is then
+// available through the Bytes method. It returns false when there
+// are no more messages or an error occurs.
+func (s *mboxScanner) Next() bool {
+	s.buf = s.buf[:0]
+	if s.started {
+		s.from, s.next = s.next, s.from
+	}
+	for s.err == nil {
+		line, err := s.r.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			s.err = err
+		}
+		start := !s.cont
+		s.cont = err == bufio.ErrBufferFull
+
+		if s.body > 0 {
+			// Lines within a body of known
+			// length are never From_ lines.
+			s.body -= len(line)
+		} else if start {
+			s.skip = isFromLine(line)
+			if s.skip {
+				s.header = true
+				if s.discard {
+					// Start the message after the
+					// skipped message in its place.
+					s.discard = false
+					s.buf = s.buf[:0]
+					s.from = append(s.from[:0], line...)
+					continue
+				}
+				if s.started {
+					s.next = append(s.next[:0], line...)
+					return true
+				}
+				s.from = append(s.from[:0], line...)
+				s.started = true
+				continue
+			}
+		} else if s.skip {
+			continue
+		}
+		if !s.started {
+			if len(bytes.TrimSpace(line)) != 0 {
+				s.err = errNoFromLine
+				return false
+			}
+			continue
+		}
+		if start {
+			if s.header && len(bytes.TrimSpace(line)) == 0 {
+				s.header = false
+				if s.dialect == mboxcl || s.dialect == mboxcl2 {
+					s.body = contentLength(s.buf)
+				}
+			}
+			line = s.unescape(line)
+		}
+		if s.discard {
+			continue
+		}
+		if len(s.buf)+len(line) > cap(s.buf) {
+			if !s.skipLong {
+				s.err = bufio.ErrTooLong
+				return false
+			}
+			s.long++
+			s.discard = true
+			continue
+		}
+		s.buf = append(s.buf, line...)
+	}
+	if s.err == io.EOF && s.started && !s.discard {
+		s.started = false
+		return true
+	}
+	return false
+}
+
+// isFromLine returns whether line is an mbox From_ line.
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("From ")) && fromLine.Match(line)
+}
+
+// unescape returns line with the escaping of its leading "From "
+// removed according to the scanner's dialect. Each line is only
+// unescaped once, so a line starting with ">>From " in an mboxrd
+// mbox becomes ">From ".
+func (s *mboxScanner) unescape(line []byte) []byte {
+	if len(line) == 0 || line[0] != '>' {
+		return line
+	}
+	switch s.dialect {
+	case mboxrd:
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			return line[1:]
+		}
+	case mboxo, mboxcl:
+		if bytes.HasPrefix(line, []byte(">From ")) {
+			return line[1:]
+		}
+	}
+	return line
+}
+
+// contentLength returns the value of the Content-Length header in
+// the message header h, or zero if it has none or it is invalid.
+func contentLength(h []byte) int {
+	for len(h) != 0 {
+		i := bytes.IndexByte(h, '\n')
+		var line []byte
+		if i < 0 {
+			line, h = h, nil
+		} else {
+			line, h = h[:i], h[i+1:]
+		}
+		const key = "content-length:"
+		if len(line) < len(key) || !bytes.EqualFold(line[:len(key)], []byte(key)) {
+			continue
+		}
+		n, err := strconv.Atoi(string(bytes.TrimSpace(line[len(key):])))
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
+
+// Bytes returns the message read by the last call to Next. The
+// returned slice is only valid until the next call to Next.
+func (s *mboxScanner) Bytes() []byte {
+	return s.buf
+}
+
+// Date returns the date given in the From_ line of the message
+// read by the last call to Next, and whether it could be parsed.
+func (s *mboxScanner) Date() (time.Time, bool) {
+	f := strings.Fields(string(s.from))
+	if len(f) < 3 {
+		return time.Time{}, false
+	}
+	date := strings.Join(f[2:], " ")
+	for _, layout := range envelopeDate {
+		t, err := time.Parse(layout, date)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// envelopeDate holds the layouts of dates in From_ lines.
+var envelopeDate = []string{
+	time.ANSIC,
+	time.UnixDate,
+	"Mon Jan _2 15:04:05 -0700 2006",
+	"Mon Jan _2 15:04 2006",
+}
+
+// Err returns the first non-EOF error encountered by the scanner.
+func (s *mboxScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
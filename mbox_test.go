@@ -0,0 +1,183 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var mboxScannerTests = []struct {
+	name     string
+	mbox     string
+	dialect  mboxDialect
+	bufSize  int
+	skipLong bool
+
+	want     []string
+	wantLong int
+	wantErr  error
+}{
+	{
+		name: "unescaped_from",
+		mbox: `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+
+From me to you.
+From here on
+From alice@example.com Mon Jan  2 16:04:05 2006
+From: alice@example.com
+
+Second.
+`,
+		dialect: mboxrd,
+		want: []string{
+			"From: alice@example.com\n\nFrom me to you.\nFrom here on\n",
+			"From: alice@example.com\n\nSecond.\n",
+		},
+	},
+	{
+		name: "mboxrd_unescape",
+		mbox: `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+
+>From a
+>Fromage
+`,
+		dialect: mboxrd,
+		want: []string{
+			"From: alice@example.com\n\nFrom a\n>Fromage\n",
+		},
+	},
+	{
+		name: "mboxrd_unescape_once",
+		mbox: `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+
+>>From b
+>>>From c
+`,
+		dialect: mboxrd,
+		want: []string{
+			"From: alice@example.com\n\n>From b\n>>From c\n",
+		},
+	},
+	{
+		name: "mboxo_unescape",
+		mbox: `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+
+>From a
+>>From b
+`,
+		dialect: mboxo,
+		want: []string{
+			"From: alice@example.com\n\nFrom a\n>>From b\n",
+		},
+	},
+	{
+		name: "mboxcl2_content_length",
+		mbox: `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+Content-Length: 54
+
+From bob@example.com Mon Jan  2 15:04:05 2006
+>From x
+From alice@example.com Mon Jan  2 16:04:05 2006
+From: alice@example.com
+
+Second.
+`,
+		dialect: mboxcl2,
+		want: []string{
+			"From: alice@example.com\nContent-Length: 54\n\nFrom bob@example.com Mon Jan  2 15:04:05 2006\n>From x\n",
+			"From: alice@example.com\n\nSecond.\n",
+		},
+	},
+	{
+		name: "mboxcl_content_length",
+		mbox: `From alice@example.com Mon Jan  2 15:04:05 2006
+From: alice@example.com
+Content-Length: 54
+
+From bob@example.com Mon Jan  2 15:04:05 2006
+>From x
+From alice@example.com Mon Jan  2 16:04:05 2006
+From: alice@example.com
+
+Second.
+`,
+		dialect: mboxcl,
+		want: []string{
+			"From: alice@example.com\nContent-Length: 54\n\nFrom bob@example.com Mon Jan  2 15:04:05 2006\nFrom x\n",
+			"From: alice@example.com\n\nSecond.\n",
+		},
+	},
+	{
+		name: "long_line",
+		mbox: "From alice@example.com Mon Jan  2 15:04:05 2006\nFrom: alice@example.com\n\n" +
+			strings.Repeat("x", 3*4096) + "\nFrom alice@example.com Mon Jan  2 16:04:05 2006\nFrom: alice@example.com\n\nSecond.\n",
+		dialect: mboxrd,
+		bufSize: 1 << 16,
+		want: []string{
+			"From: alice@example.com\n\n" + strings.Repeat("x", 3*4096) + "\n",
+			"From: alice@example.com\n\nSecond.\n",
+		},
+	},
+	{
+		name: "too_long",
+		mbox: "From alice@example.com Mon Jan  2 15:04:05 2006\nFrom: alice@example.com\n\n" +
+			strings.Repeat("x", 128) + "\n",
+		dialect: mboxrd,
+		bufSize: 64,
+		wantErr: bufio.ErrTooLong,
+	},
+	{
+		name: "skip_long",
+		mbox: "From alice@example.com Mon Jan  2 15:04:05 2006\nFrom: alice@example.com\n\n" +
+			strings.Repeat("x", 128) + "\nFrom alice@example.com Mon Jan  2 16:04:05 2006\nFrom: alice@example.com\n\nSecond.\n",
+		dialect:  mboxrd,
+		bufSize:  64,
+		skipLong: true,
+		want: []string{
+			"From: alice@example.com\n\nSecond.\n",
+		},
+		wantLong: 1,
+	},
+	{
+		name:    "no_from_line",
+		mbox:    "From: alice@example.com\n\nHello.\n",
+		dialect: mboxrd,
+		wantErr: errNoFromLine,
+	},
+}
+
+func TestMboxScanner(t *testing.T) {
+	for _, test := range mboxScannerTests {
+		t.Run(test.name, func(t *testing.T) {
+			bufSize := test.bufSize
+			if bufSize == 0 {
+				bufSize = 1 << 10
+			}
+			ms := newMboxScanner(strings.NewReader(test.mbox), make([]byte, bufSize), test.dialect)
+			ms.skipLong = test.skipLong
+			var got []string
+			for ms.Next() {
+				got = append(got, string(ms.Bytes()))
+			}
+			if err := ms.Err(); err != test.wantErr {
+				t.Errorf("unexpected error: got:%v want:%v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("unexpected messages:\ngot: %q\nwant:%q", got, test.want)
+			}
+			if ms.long != test.wantLong {
+				t.Errorf("unexpected number of skipped messages: got:%d want:%d", ms.long, test.wantLong)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/exp/rand"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/community"
+	"gonum.org/v1/gonum/graph/multi"
+	"gonum.org/v1/gonum/graph/network"
+	"gonum.org/v1/gonum/graph/simple"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+// nodeAttrs holds attributes of a person that are measured
+// once the graph has been built.
+type nodeAttrs struct {
+	// degree is the number of distinct
+	// neighbors of the node.
+	degree int
+
+	// wdegree is the sum of the weights
+	// of the edges incident to the node.
+	wdegree float64
+
+	// sent and received are the numbers of lines
+	// from and to the node in a directed graph.
+	// They are only valid if hasMessages is true.
+	sent, received int
+	hasMessages    bool
+
+	// betweenness is the normalized betweenness
+	// centrality of the node. It is only valid if
+	// hasBetweenness is true.
+	betweenness    float64
+	hasBetweenness bool
+
+	// community is the label of the community
+	// of the node. It is only valid if
+	// hasCommunity is true.
+	community    int
+	hasCommunity bool
+
+	// first and last are the dates of the
+	// earliest and latest dated messages
+	// of the node. They are zero if the node
+	// has no dated messages.
+	first, last time.Time
+
+	// kind is whether the node is a person
+	// or a domain in a bipartite graph, and
+	// is empty otherwise.
+	kind string
+}
+
+// measureDegrees records the degree and weighted degree of each
+// node in g.
+func (g addrGraph) measureDegrees() {
+	nodes := g.Nodes()
+	for nodes.Next() {
+		n := nodes.Node().(person)
+		n.attrs.degree, n.attrs.wdegree = g.degree(n.ID())
+	}
+}
+
+// degree returns the number of distinct neighbors of the node
+// with the given ID and the sum of the weights of its edges. In
+// a directed graph edges in both directions are included. The
+// weight of a self-loop is counted at both of its ends.
+func (g addrGraph) degree(id int64) (degree int, wdegree float64) {
+	neighbors := make(map[int64]bool)
+	d, directed := g.multigraph.(graph.Directed)
+	to := g.From(id)
+	for to.Next() {
+		vid := to.Node().ID()
+		neighbors[vid] = true
+		w, _ := g.Weight(id, vid)
+		wdegree += w
+		if vid == id && !directed {
+			wdegree += w
+		}
+	}
+	if directed {
+		from := d.To(id)
+		for from.Next() {
+			uid := from.Node().ID()
+			neighbors[uid] = true
+			w, _ := g.Weight(uid, id)
+			wdegree += w
+		}
+	}
+	return len(neighbors), wdegree
+}
+
+// measureContacts records the dates of the earliest and latest
+// dated messages of each node in g.
+func (g addrGraph) measureContacts() {
+	edges := g.Edges()
+	for edges.Next() {
+		e := edges.Edge().(multi.Edge)
+		sd, ed := edge{Edge: e}.span()
+		if sd.IsZero() {
+			continue
+		}
+		for _, n := range []graph.Node{e.F, e.T} {
+			attrs := n.(person).attrs
+			if attrs.first.IsZero() || sd.Before(attrs.first) {
+				attrs.first = sd
+			}
+			if attrs.last.IsZero() || ed.After(attrs.last) {
+				attrs.last = ed
+			}
+		}
+	}
+}
+
+// measureMessages records the number of lines sent from and
+// received by each node in the directed graph g.
+func (g addrGraph) measureMessages() {
+	nodes := g.Nodes()
+	for nodes.Next() {
+		nodes.Node().(person).attrs.hasMessages = true
+	}
+	edges := g.Edges()
+	for edges.Next() {
+		e := edges.Edge().(multi.Edge)
+		n := e.Len()
+		e.F.(person).attrs.sent += n
+		e.T.(person).attrs.received += n
+	}
+}
+
+// measureBetweenness records the normalized betweenness centrality
+// of each node in g. Edge directions and parallel lines are ignored
+// and path lengths are counted in edges, since edge weights measure
+// the strength of a contact rather than a distance.
+func (g addrGraph) measureBetweenness() {
+	s := g.simple()
+	nodes := graph.NodesOf(g.Nodes())
+
+	// Betweenness counts each pair of end points
+	// in both directions in an undirected graph.
+	norm := 1.0
+	if n := float64(len(nodes)); n > 2 {
+		norm = 1 / ((n - 1) * (n - 2))
+	}
+	cb := network.Betweenness(s)
+	for _, n := range nodes {
+		attrs := n.(person).attrs
+		attrs.betweenness = cb[n.ID()] * norm
+		attrs.hasBetweenness = true
+	}
+}
+
+// measureCommunities records the label of the community of each
+// node in g found by Louvain modularity optimization at the given
+// resolution, using the weights of g with edge directions ignored.
+// The optimization is randomized from the given seed. Communities
+// are labelled from zero in order of decreasing size, with ties
+// ordered by their first address.
+func (g addrGraph) measureCommunities(resolution float64, seed uint64) {
+	w := simple.NewWeightedUndirectedGraph(0, 0)
+	nodes := g.Nodes()
+	for nodes.Next() {
+		w.AddNode(simple.Node(nodes.Node().ID()))
+	}
+	edges := g.Edges()
+	for edges.Next() {
+		e := edges.Edge()
+		uid, vid := e.From().ID(), e.To().ID()
+		if uid == vid {
+			continue
+		}
+		weight, _ := g.Weight(uid, vid)
+		if prev, ok := w.Weight(uid, vid); ok {
+			weight += prev
+		}
+		w.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(uid), T: simple.Node(vid), W: weight})
+	}
+
+	comms := community.Modularize(w, resolution, rand.NewSource(seed)).Communities()
+	labels := make([][]string, len(comms))
+	for i, c := range comms {
+		addrs := make([]string, len(c))
+		for j, n := range c {
+			addrs[j] = g.Node(n.ID()).(person).addr
+		}
+		sort.Strings(addrs)
+		labels[i] = addrs
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if len(labels[i]) != len(labels[j]) {
+			return len(labels[i]) > len(labels[j])
+		}
+		return labels[i][0] < labels[j][0]
+	})
+	for i, c := range labels {
+		for _, addr := range c {
+			attrs := g.Node(g.id[addr]).(person).attrs
+			attrs.community = i
+			attrs.hasCommunity = true
+		}
+	}
+}
+
+// simple returns a simple undirected graph with the node IDs of g
+// and an edge between each pair of distinct nodes with an edge in
+// g in either direction.
+func (g addrGraph) simple() *simple.UndirectedGraph {
+	s := simple.NewUndirectedGraph()
+	nodes := g.Nodes()
+	for nodes.Next() {
+		s.AddNode(simple.Node(nodes.Node().ID()))
+	}
+	edges := g.Edges()
+	for edges.Next() {
+		e := edges.Edge()
+		uid, vid := e.From().ID(), e.To().ID()
+		if uid == vid || s.HasEdgeBetween(uid, vid) {
+			continue
+		}
+		s.SetEdge(simple.Edge{F: simple.Node(uid), T: simple.Node(vid)})
+	}
+	return s
+}
+
+// components returns the addresses of the connected components of g,
+// ignoring edge directions, largest first. Components of the same
+// size are ordered by their first address, and the addresses in each
+// component are sorted.
+func (g addrGraph) components() [][]string {
+	cc := topo.ConnectedComponents(g.simple())
+	comps := make([][]string, len(cc))
+	for i, c := range cc {
+		addrs := make([]string, len(c))
+		for j, n := range c {
+			addrs[j] = g.Node(n.ID()).(person).addr
+		}
+		sort.Strings(addrs)
+		comps[i] = addrs
+	}
+	sort.Slice(comps, func(i, j int) bool {
+		if len(comps[i]) != len(comps[j]) {
+			return len(comps[i]) > len(comps[j])
+		}
+		return comps[i][0] < comps[j][0]
+	})
+	return comps
+}
@@ -0,0 +1,42 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// marshalMermaid writes g to dst as a left to right Mermaid flowchart.
+// Nodes are given IDs n0, n1, ... in address order and labelled with
+// their address, and edges are labelled with their weight. Edges are
+// drawn as arrows if g is directed. Mermaid labels cannot contain
+// double quotes, so they are replaced with the #quot; entity.
+func marshalMermaid(dst io.Writer, g addrGraph) error {
+	people := g.sortedPeople()
+	index := make(map[int64]int, len(people))
+
+	w := bufio.NewWriter(dst)
+	fmt.Fprintln(w, "graph LR")
+	for i, p := range people {
+		index[p.ID()] = i
+		fmt.Fprintf(w, "  n%d[\"%s\"]\n", i, strings.Replace(p.addr, `"`, "#quot;", -1))
+	}
+
+	link := "---"
+	if g.isDirected() {
+		link = "-->"
+	}
+	for _, e := range g.sortedEdges() {
+		u, v := e.F.ID(), e.T.ID()
+		_, err := fmt.Fprintf(w, "  n%d %s|%v| n%d\n", index[u], link, g.weight(e.Lines), index[v])
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,44 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// marshalPajek writes g to dst in the Pajek .net format. Nodes are
+// renumbered from 1 in order of their address and labelled with their
+// address. Edges are written as arcs if g is directed. Pajek labels
+// cannot contain double quotes, so they are replaced with single
+// quotes.
+func marshalPajek(dst io.Writer, g addrGraph) error {
+	people := g.sortedPeople()
+	index := make(map[int64]int, len(people))
+
+	w := bufio.NewWriter(dst)
+	fmt.Fprintf(w, "*Vertices %d\n", len(people))
+	for i, p := range people {
+		index[p.ID()] = i + 1
+		fmt.Fprintf(w, "%d \"%s\"\n", i+1, strings.Replace(p.addr, `"`, "'", -1))
+	}
+
+	if g.isDirected() {
+		fmt.Fprintln(w, "*Arcs")
+	} else {
+		fmt.Fprintln(w, "*Edges")
+	}
+	for _, e := range g.sortedEdges() {
+		u, v := e.From().ID(), e.To().ID()
+		weight, _ := g.Weight(u, v)
+		_, err := fmt.Fprintf(w, "%d %d %v\n", index[u], index[v], weight)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,107 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPajekRoundTrip(t *testing.T) {
+	originators, recipients, _ := parseHeaders("from,to,cc,bcc")
+	for _, directed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("directed=%t", directed), func(t *testing.T) {
+			g, _, err := buildGraph(strings.NewReader(testMbox), options{
+				directed:    directed,
+				weight:      messageCount,
+				originators: originators,
+				recipients:  recipients,
+				bufSize:     1 << 16,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var buf bytes.Buffer
+			err = marshalPajek(&buf, g)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			gotAddrs, gotEdges, gotDirected, err := readPajek(&buf)
+			if err != nil {
+				t.Fatalf("failed to read Pajek output: %v", err)
+			}
+
+			if gotDirected != directed {
+				t.Errorf("unexpected directedness: got:%t want:%t", gotDirected, directed)
+			}
+			var wantAddrs []string
+			for _, p := range g.sortedPeople() {
+				wantAddrs = append(wantAddrs, p.addr)
+			}
+			if !reflect.DeepEqual(gotAddrs, wantAddrs) {
+				t.Errorf("unexpected vertices: got:%q want:%q", gotAddrs, wantAddrs)
+			}
+			wantEdges := make(map[[2]string]float64)
+			for _, e := range g.sortedEdges() {
+				u, v := e.From().(person), e.To().(person)
+				wantEdges[[2]string{u.addr, v.addr}], _ = g.Weight(u.ID(), v.ID())
+			}
+			if !reflect.DeepEqual(gotEdges, wantEdges) {
+				t.Errorf("unexpected edges: got:%v want:%v", gotEdges, wantEdges)
+			}
+		})
+	}
+}
+
+// readPajek returns the vertex labels in order, the weighted edges
+// between labels and whether the edges are arcs in the Pajek .net
+// data read from buf.
+func readPajek(buf *bytes.Buffer) (addrs []string, edges map[[2]string]float64, directed bool, err error) {
+	sc := bufio.NewScanner(buf)
+	if !sc.Scan() {
+		return nil, nil, false, fmt.Errorf("missing vertices line")
+	}
+	var n int
+	_, err = fmt.Sscanf(sc.Text(), "*Vertices %d", &n)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	for i := 1; i <= n && sc.Scan(); i++ {
+		id, label, ok := strings.Cut(sc.Text(), " ")
+		if !ok || id != strconv.Itoa(i) {
+			return nil, nil, false, fmt.Errorf("invalid vertex line: %q", sc.Text())
+		}
+		addrs = append(addrs, strings.Trim(label, `"`))
+	}
+	if !sc.Scan() {
+		return nil, nil, false, fmt.Errorf("missing edges line")
+	}
+	switch sc.Text() {
+	case "*Arcs":
+		directed = true
+	case "*Edges":
+	default:
+		return nil, nil, false, fmt.Errorf("invalid edges line: %q", sc.Text())
+	}
+	edges = make(map[[2]string]float64)
+	for sc.Scan() {
+		var u, v int
+		var w float64
+		_, err = fmt.Sscanf(sc.Text(), "%d %d %g", &u, &v, &w)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if u < 1 || u > n || v < 1 || v > n {
+			return nil, nil, false, fmt.Errorf("invalid edge line: %q", sc.Text())
+		}
+		edges[[2]string{addrs[u-1], addrs[v-1]}] = w
+	}
+	return addrs, edges, directed, sc.Err()
+}
@@ -0,0 +1,98 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progress reports the progress of reading messages on a single
+// line that is rewritten in place. Log output written through a
+// progress is placed above the progress line.
+type progress struct {
+	mu   sync.Mutex
+	w    io.Writer
+	line string
+	last time.Time
+
+	// total is the number of input bytes
+	// if it is known, and read is the number
+	// read so far. read is accessed
+	// atomically.
+	total int64
+	read  int64
+}
+
+// progressInterval is the interval between progress reports.
+const progressInterval = time.Second
+
+// report writes the current progress if progressInterval has
+// passed since the last report.
+func (p *progress) report(messages int64, nodes, lines int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	p.write(messages, nodes, lines)
+}
+
+// write replaces the progress line with the given counts.
+func (p *progress) write(messages int64, nodes, lines int) {
+	line := fmt.Sprintf("messages: %d nodes: %d lines: %d", messages, nodes, lines)
+	if p.total > 0 {
+		line += fmt.Sprintf(" (%.1f%%)", 100*float64(atomic.LoadInt64(&p.read))/float64(p.total))
+	}
+	p.clear()
+	p.line = line
+	io.WriteString(p.w, line)
+}
+
+// Write writes b above the progress line.
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.w.Write(b)
+	if err == nil {
+		_, err = io.WriteString(p.w, p.line)
+	}
+	return n, err
+}
+
+// done writes the final counts and ends the progress line.
+func (p *progress) done(messages int64, nodes, lines int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.write(messages, nodes, lines)
+	io.WriteString(p.w, "\n")
+	p.line = ""
+}
+
+// clear blanks the progress line and returns to its start.
+func (p *progress) clear() {
+	if p.line != "" {
+		io.WriteString(p.w, "\r"+strings.Repeat(" ", len(p.line))+"\r")
+	}
+}
+
+// countingReader counts the bytes read from an io.Reader.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r countingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
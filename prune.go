@@ -0,0 +1,160 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/multi"
+)
+
+// pruneEdges removes all edges with a weight less than min
+// and then removes any nodes left without edges.
+func (g addrGraph) pruneEdges(min float64) {
+	var remove []graph.Line
+	edges := g.Edges()
+	for edges.Next() {
+		e := edges.Edge().(multi.Edge)
+		if g.weight(e.Lines) < min {
+			remove = append(remove, graph.LinesOf(e.Lines)...)
+		}
+	}
+	for _, l := range remove {
+		g.RemoveLine(l.From().ID(), l.To().ID(), l.ID())
+	}
+	g.removeIsolated()
+}
+
+// pruneNodes removes all nodes with fewer than min distinct
+// neighbors, and their edges, from g. Removal is repeated until
+// no node remains with fewer than min neighbors, since removing
+// a node reduces the degree of its neighbors.
+func (g addrGraph) pruneNodes(min int) {
+	for {
+		var remove []int64
+		nodes := g.Nodes()
+		for nodes.Next() {
+			id := nodes.Node().ID()
+			if d, _ := g.degree(id); d < min {
+				remove = append(remove, id)
+			}
+		}
+		if len(remove) == 0 {
+			return
+		}
+		for _, id := range remove {
+			g.removeNode(id)
+		}
+	}
+}
+
+// keepReciprocal removes all edges of the directed graph g that
+// have no edge in the opposite direction and then removes any nodes
+// left without edges.
+func (g addrGraph) keepReciprocal() {
+	d := g.multigraph.(graph.Directed)
+	var remove []graph.Line
+	edges := g.Edges()
+	for edges.Next() {
+		e := edges.Edge().(multi.Edge)
+		if !d.HasEdgeFromTo(e.T.ID(), e.F.ID()) {
+			remove = append(remove, graph.LinesOf(e.Lines)...)
+		}
+	}
+	for _, l := range remove {
+		g.RemoveLine(l.From().ID(), l.To().ID(), l.ID())
+	}
+	g.removeIsolated()
+}
+
+// undirected returns an undirected copy of the directed graph g.
+// Lines in both directions between a pair of nodes are held by a
+// single edge, so its weight combines both directions. Node
+// attributes are shared with g.
+func (g addrGraph) undirected() addrGraph {
+	u := newAddrGraph(false, g.weight)
+	nodes := g.Nodes()
+	for nodes.Next() {
+		p := nodes.Node().(person)
+		u.AddNode(p)
+		u.id[p.addr] = p.ID()
+	}
+	edges := g.Edges()
+	for edges.Next() {
+		e := edges.Edge().(multi.Edge)
+		for e.Next() {
+			m := e.Line().(message)
+			m.Line = u.NewLine(u.Node(m.From().ID()), u.Node(m.To().ID()))
+			u.SetLine(m)
+		}
+	}
+	return u
+}
+
+// keepLargestComponent removes all nodes that are not in the
+// largest connected component of g, ignoring edge directions.
+func (g addrGraph) keepLargestComponent() {
+	comps := g.components()
+	if len(comps) < 2 {
+		return
+	}
+	for _, c := range comps[1:] {
+		for _, addr := range c {
+			g.removeNode(g.id[addr])
+		}
+	}
+}
+
+// keepTop removes all but the n nodes with the highest weighted
+// degree, and their edges, from g. Ties are broken by address.
+func (g addrGraph) keepTop(n int) {
+	nodes := graph.NodesOf(g.Nodes())
+	if len(nodes) <= n {
+		return
+	}
+	wdegree := make(map[int64]float64, len(nodes))
+	for _, u := range nodes {
+		_, wdegree[u.ID()] = g.degree(u.ID())
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		wi, wj := wdegree[nodes[i].ID()], wdegree[nodes[j].ID()]
+		if wi != wj {
+			return wi > wj
+		}
+		return nodes[i].(person).addr < nodes[j].(person).addr
+	})
+	for _, u := range nodes[n:] {
+		g.removeNode(u.ID())
+	}
+}
+
+// removeIsolated removes all nodes without edges from g.
+func (g addrGraph) removeIsolated() {
+	for _, n := range graph.NodesOf(g.Nodes()) {
+		if g.isolated(n.ID()) {
+			g.removeNode(n.ID())
+		}
+	}
+}
+
+// isolated returns whether the node with the given ID has no
+// edges.
+func (g addrGraph) isolated(id int64) bool {
+	if g.From(id).Len() != 0 {
+		return false
+	}
+	if d, ok := g.multigraph.(graph.Directed); ok {
+		return d.To(id).Len() == 0
+	}
+	return true
+}
+
+// removeNode removes the node with the given ID and its edges
+// from g, and forgets its address.
+func (g addrGraph) removeNode(id int64) {
+	delete(g.id, g.Node(id).(person).addr)
+	g.RemoveNode(id)
+}
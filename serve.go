@@ -0,0 +1,156 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// server is an HTTP server that responds to requests holding
+// mbox data with the graph of the messages.
+type server struct {
+	// opts holds the options of the builder of
+	// each request.
+	opts options
+
+	// maxBytes is the maximum request body size.
+	maxBytes int64
+
+	// build prunes and measures the graph built
+	// for a request and encode writes it to the
+	// response. Since they are called for each
+	// request, they must not exit the process;
+	// options are validated before serving.
+	build  func(addrGraph) addrGraph
+	encode func(io.Writer, string, addrGraph) error
+}
+
+// listenAndServe listens on the TCP network address addr and
+// serves requests, each of which must be handled within timeout.
+func (s *server) listenAndServe(addr string, timeout time.Duration) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           http.TimeoutHandler(s, timeout, "request timed out"),
+		ReadHeaderTimeout: timeout,
+	}
+	slog.Info("serving", "address", addr)
+	return srv.ListenAndServe()
+}
+
+// ServeHTTP responds to a POST request with the graph of the mbox
+// data in its body, or in the files of its body if it is
+// multipart/form-data. Compressed mbox data is transparently
+// decompressed. The format of the response is given by the format
+// query parameter and is JSON by default.
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if _, ok := formatExt[format]; !ok || format == "gephi-csv" {
+		http.Error(w, fmt.Sprintf("invalid format: %q", format), http.StatusBadRequest)
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, s.maxBytes)
+
+	// Each request has its own builder and graph, sharing
+	// only the read-only options.
+	b := newBuilder(s.opts)
+
+	bufSize := s.opts.bufSize
+	if int64(bufSize) > s.maxBytes {
+		bufSize = int(s.maxBytes)
+	}
+	buf := make([]byte, bufSize)
+	err := eachPart(req, func(r io.Reader) error {
+		r, err := decompress(r)
+		if err != nil {
+			return err
+		}
+		return b.eachMessage(r, buf, b.addMessage)
+	})
+	if err == bufio.ErrTooLong {
+		http.Error(w, fmt.Sprintf("message larger than %d bytes", bufSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if b.thread {
+		b.linkThreads()
+	}
+
+	var out bytes.Buffer
+	err = s.encode(&out, format, s.build(b.g))
+	if err != nil {
+		slog.Error("failed to format graph", "format", format, "error", err)
+		http.Error(w, "failed to format graph", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType[format])
+	w.Write(out.Bytes())
+}
+
+// eachPart calls fn with each file in the multipart/form-data body
+// of req, or with the body of req if it is not multipart.
+func eachPart(req *http.Request, fn func(io.Reader) error) error {
+	typ, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if typ != "multipart/form-data" {
+		return fn(req.Body)
+	}
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return err
+	}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p.FileName() == "" {
+			continue
+		}
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// contentType holds the media type of the response for each
+// format served.
+var contentType = map[string]string{
+	"dot":           "text/vnd.graphviz; charset=utf-8",
+	"dot-clustered": "text/vnd.graphviz; charset=utf-8",
+	"gexf":          "application/xml; charset=utf-8",
+	"tgexf":         "application/xml; charset=utf-8",
+	"graphml":       "application/xml; charset=utf-8",
+	"gml":           "text/plain; charset=utf-8",
+	"edgelist":      "text/plain; charset=utf-8",
+	"json":          "application/json",
+	"pajek":         "text/plain; charset=utf-8",
+	"adjacency":     "text/csv; charset=utf-8",
+	"mermaid":       "text/plain; charset=utf-8",
+	"cypher":        "text/plain; charset=utf-8",
+	"graphson":      "application/json",
+	"summary":       "text/plain; charset=utf-8",
+	"svg":           "image/svg+xml",
+}
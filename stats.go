@@ -0,0 +1,159 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// stats holds counts of the messages handled by a builder. The
+// fields are accessed atomically since messages are prepared
+// concurrently.
+//
+// Each message seen is counted in exactly one of oversize,
+// malformed, dropped, tooMany, undated, outside, tooFew, duplicate or added.
+type stats struct {
+	// messages is the number of messages seen.
+	messages int64
+
+	// oversize is the number of messages skipped
+	// because they were larger than the message
+	// buffer.
+	oversize int64
+
+	// malformed is the number of messages that
+	// could not be parsed or had no address
+	// headers.
+	malformed int64
+
+	// dropped is the number of messages dropped
+	// by the drop-from pattern or the dropped
+	// message IDs.
+	dropped int64
+
+	// tooMany is the number of messages skipped
+	// because a recipient header had more than
+	// the maximum number of addresses.
+	tooMany int64
+
+	// undated is the number of messages skipped
+	// because they have no date.
+	undated int64
+
+	// outside is the number of messages outside
+	// the time window.
+	outside int64
+
+	// tooFew is the number of messages without
+	// enough addresses to add a line.
+	tooFew int64
+
+	// duplicate is the number of messages
+	// skipped because their message ID had
+	// already been added.
+	duplicate int64
+
+	// added is the number of messages added to
+	// the graph or retained for threading.
+	added int64
+
+	// lines is the number of lines added to
+	// the graph.
+	lines int64
+
+	// excludedAddrs and droppedAddrs count the
+	// addresses matched by the exclude and
+	// drop-from patterns. They are nil unless
+	// the counts are needed.
+	excludedAddrs, droppedAddrs *tally
+}
+
+func (s *stats) count(n *int64) {
+	atomic.AddInt64(n, 1)
+}
+
+// writeTo writes a summary of s to w.
+func (s *stats) writeTo(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `messages:            %d
+oversize:            %d
+malformed:           %d
+dropped:             %d
+too many recipients: %d
+undated:             %d
+outside window:      %d
+too few addresses:   %d
+duplicates:          %d
+added:               %d
+lines:               %d
+`,
+		atomic.LoadInt64(&s.messages),
+		atomic.LoadInt64(&s.oversize),
+		atomic.LoadInt64(&s.malformed),
+		atomic.LoadInt64(&s.dropped),
+		atomic.LoadInt64(&s.tooMany),
+		atomic.LoadInt64(&s.undated),
+		atomic.LoadInt64(&s.outside),
+		atomic.LoadInt64(&s.tooFew),
+		atomic.LoadInt64(&s.duplicate),
+		atomic.LoadInt64(&s.added),
+		atomic.LoadInt64(&s.lines),
+	)
+	return err
+}
+
+// tally counts occurrences of strings. It is safe for concurrent use.
+// Adding to a nil tally is a no-op.
+type tally struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (t *tally) add(s string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.counts == nil {
+		t.counts = make(map[string]int)
+	}
+	t.counts[s]++
+	t.mu.Unlock()
+}
+
+// writeTo writes the n most frequent strings in t to w under the
+// given title, most frequent first.
+func (t *tally) writeTo(w io.Writer, title string, n int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]string, 0, len(t.counts))
+	for k := range t.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := t.counts[keys[i]], t.counts[keys[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	_, err := fmt.Fprintf(w, "%s:\n", title)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		_, err = fmt.Fprintf(w, "%8d %s\n", t.counts[k], k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
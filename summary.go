@@ -0,0 +1,126 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"gonum.org/v1/gonum/graph/multi"
+)
+
+// maxContacts is the maximum number of contacts listed for each
+// person by marshalSummary.
+const maxContacts = 10
+
+// marshalSummary writes a table of the top contacts of each person
+// in g to dst, in address order. Contacts are listed in descending
+// order of weight, with the number of messages and the dates of the
+// first and last message between the pair. Messages in both
+// directions are combined if g is directed. If focus is not empty,
+// only the contacts of the person with that address are written.
+// If inverse is true, the weights of g are distances and contacts
+// are listed in ascending order of weight so that the closest
+// contacts are still listed first.
+func marshalSummary(dst io.Writer, g addrGraph, focus string, inverse bool) error {
+	if g.isDirected() {
+		g = g.undirected()
+	}
+
+	var people []person
+	if focus == "" {
+		people = g.sortedPeople()
+	} else {
+		id, ok := g.id[focus]
+		if !ok {
+			_, err := fmt.Fprintf(dst, "%s: no contacts\n", focus)
+			return err
+		}
+		people = []person{g.Node(id).(person)}
+	}
+
+	w := bufio.NewWriter(dst)
+	for i, p := range people {
+		if i != 0 {
+			fmt.Fprintln(w)
+		}
+		if name := p.name(); name != p.addr {
+			fmt.Fprintf(w, "%s (%s)\n", p.addr, name)
+		} else {
+			fmt.Fprintln(w, p.addr)
+		}
+
+		contacts := topContacts(g, p, inverse)
+		if len(contacts) == 0 {
+			fmt.Fprintln(w, "  no contacts")
+			continue
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  contact\tweight\tmessages\tfirst\tlast")
+		for j, c := range contacts {
+			if j == maxContacts {
+				fmt.Fprintf(tw, "  (%d more)\n", len(contacts)-maxContacts)
+				break
+			}
+			fmt.Fprintf(tw, "  %s\t%v\t%d\t%s\t%s\n", c.addr, c.weight, c.count, summaryDate(c.first), summaryDate(c.last))
+		}
+		err := tw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// neighbor is a summary of the messages between a person and
+// one of their neighbors.
+type neighbor struct {
+	addr        string
+	weight      float64
+	count       int
+	first, last time.Time
+}
+
+// topContacts returns the contacts of p in the undirected graph g
+// sorted by descending weight, or ascending weight if inverse is
+// true, then by address.
+func topContacts(g addrGraph, p person, inverse bool) []neighbor {
+	var contacts []neighbor
+	to := g.From(p.ID())
+	for to.Next() {
+		q := to.Node().(person)
+		e := edge{multi.Edge{F: p, T: q, Lines: g.Lines(p.ID(), q.ID())}, g.weight}
+		first, last := e.span()
+		contacts = append(contacts, neighbor{
+			addr:   q.addr,
+			weight: e.Weight(),
+			count:  e.Len(),
+			first:  first,
+			last:   last,
+		})
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		if contacts[i].weight != contacts[j].weight {
+			if inverse {
+				return contacts[i].weight < contacts[j].weight
+			}
+			return contacts[i].weight > contacts[j].weight
+		}
+		return contacts[i].addr < contacts[j].addr
+	})
+	return contacts
+}
+
+// summaryDate returns the date of t, or "-" if t is zero.
+func summaryDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02")
+}
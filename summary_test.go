@@ -0,0 +1,39 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopContactsInverse(t *testing.T) {
+	originators, recipients, _ := parseHeaders("from,to,cc,bcc")
+	g, _, err := buildGraph(strings.NewReader(testMbox), options{
+		weight:      messageCount,
+		originators: originators,
+		recipients:  recipients,
+		bufSize:     1 << 16,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g = g.undirected()
+
+	inv := g
+	inv.weight = inverted(g.weight)
+	for _, p := range g.sortedPeople() {
+		want := topContacts(g, p, false)
+		got := topContacts(inv, p, true)
+		if len(got) != len(want) {
+			t.Fatalf("unexpected number of contacts for %s: got:%d want:%d", p.addr, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].addr != want[i].addr {
+				t.Errorf("unexpected contact %d for %s: got:%s want:%s", i, p.addr, got[i].addr, want[i].addr)
+			}
+		}
+	}
+}
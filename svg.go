@@ -0,0 +1,45 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// layouts holds the Graphviz layout engines that can be used to
+// render SVG.
+var layouts = map[string]bool{
+	"dot":   true,
+	"neato": true,
+	"fdp":   true,
+	"sfdp":  true,
+}
+
+// renderSVG writes the DOT graph src to dst as SVG laid out by the
+// Graphviz layout engine of the given name, which must be on the
+// PATH.
+func renderSVG(dst io.Writer, src []byte, layout string) error {
+	path, err := exec.LookPath(layout)
+	if err != nil {
+		return fmt.Errorf("Graphviz %s not found: install Graphviz or use -format dot: %v", layout, err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, "-Tsvg")
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stdout = dst
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s failed: %v: %s", layout, err, msg)
+		}
+		return fmt.Errorf("%s failed: %v", layout, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright ©2018 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log/slog"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// retain retains the message record r for thread reconstruction
+// by linkThreads.
+func (b *builder) retain(r *record) {
+	b.posts = append(b.posts, r)
+	if r.mid != "" {
+		if b.index == nil {
+			b.index = make(map[string]*record)
+		}
+		b.index[r.mid] = r
+	}
+}
+
+// parentID returns the message ID of the message that the message
+// with header h replies to. The In-Reply-To header is used if it is
+// present, otherwise the last message ID in the References header.
+func parentID(h mail.Header) string {
+	ids := messageIDs(h.Get("in-reply-to"))
+	if len(ids) != 0 {
+		return ids[0]
+	}
+	ids = messageIDs(h.Get("references"))
+	if len(ids) != 0 {
+		return ids[len(ids)-1]
+	}
+	return ""
+}
+
+// messageIDs returns the angle bracketed message IDs in s.
+func messageIDs(s string) []string {
+	var ids []string
+	for {
+		i := strings.Index(s, "<")
+		if i < 0 {
+			return ids
+		}
+		j := strings.Index(s[i:], ">")
+		if j < 0 {
+			return ids
+		}
+		ids = append(ids, s[i:i+j+1])
+		s = s[i+j+1:]
+	}
+}
+
+// linkThreads adds lines from the senders of each retained reply
+// to the senders of the message it replies to. Replies outside the
+// time window and replies to messages that were not retained are
+// skipped.
+//
+// If b.bySubject is true, messages without reply headers are grouped
+// by their normalized subject and lines are added from the senders
+// of each message in a group to the senders of the message before it
+// by date.
+func (b *builder) linkThreads() {
+	var (
+		subjects []string
+		groups   map[string][]*record
+	)
+	for _, p := range b.posts {
+		if p.parent == "" {
+			if b.bySubject && p.subject != "" && !p.date.IsZero() {
+				if groups == nil {
+					groups = make(map[string][]*record)
+				}
+				if _, ok := groups[p.subject]; !ok {
+					subjects = append(subjects, p.subject)
+				}
+				groups[p.subject] = append(groups[p.subject], p)
+			}
+			continue
+		}
+		if !b.inWindow(p.date) {
+			continue
+		}
+		parent, ok := b.index[p.parent]
+		if !ok {
+			slog.Debug("no parent for message", "parent", p.parent, "message-id", p.mid)
+			continue
+		}
+		b.link(p, parent)
+	}
+
+	for _, s := range subjects {
+		group := groups[s]
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].date.Before(group[j].date)
+		})
+		for i, p := range group[1:] {
+			if !b.inWindow(p.date) {
+				continue
+			}
+			b.link(p, group[i])
+		}
+	}
+}
+
+// link adds lines from the senders of the reply to the senders
+// of its parent.
+func (b *builder) link(reply, parent *record) {
+	g := b.graph(reply.date)
+	var addrs []string
+	for _, f := range [][]address{reply.found, parent.found} {
+		for _, a := range f {
+			addrs = append(addrs, a.addr)
+		}
+	}
+	k := len(dedup(addrs))
+	for _, x := range reply.found {
+		for _, y := range parent.found {
+			if x.addr == y.addr && !b.selfLoops || b.crossDomain && sameDomain(x.addr, y.addr) {
+				continue
+			}
+			var raw []string
+			for _, r := range []string{x.raw, y.raw} {
+				if r != "" {
+					raw = append(raw, r)
+				}
+			}
+			b.addLine(g, b.node(g, x.addr), b.node(g, y.addr), reply, raw, k)
+		}
+	}
+	b.named(g, reply.found)
+	b.named(g, parent.found)
+}
+
+// normalizeSubject returns the subject s with any leading reply
+// and forward prefixes removed and surrounding white space trimmed.
+func normalizeSubject(s string) string {
+	s = strings.TrimSpace(s)
+	for {
+		lower := strings.ToLower(s)
+		var n int
+		for _, prefix := range []string{"re:", "fwd:", "fw:"} {
+			if strings.HasPrefix(lower, prefix) {
+				n = len(prefix)
+				break
+			}
+		}
+		if n == 0 {
+			return s
+		}
+		s = strings.TrimSpace(s[n:])
+	}
+}